@@ -9,6 +9,10 @@ package balancer
 
 import (
 	"errors" // 标准错误包，用于创建错误对象
+	"fmt"
+	"sort"
+	"sync"
+	"time"
 )
 
 // 定义包级别的错误变量
@@ -22,6 +26,10 @@ var (
 	// AlgorithmNotSupportedError 表示请求的负载均衡算法不支持
 	// 当Build函数收到未注册的算法名称时返回此错误
 	AlgorithmNotSupportedError = errors.New("algorithm not supported")
+
+	// AlgorithmExistsError 表示算法名称已经被注册过
+	// 当Register收到重复的算法名称时返回此错误，避免自定义算法悄悄覆盖内置算法
+	AlgorithmExistsError = errors.New("algorithm already registered")
 )
 
 // Balancer 接口定义了负载均衡器的核心行为
@@ -58,6 +66,30 @@ type Balancer interface {
 	// 使用场景：请求处理完成时调用
 	// Inc和Done配对使用，追踪服务器的实时负载
 	Done(string)
+
+	// Report 报告一次请求的结果
+	// 参数：服务器地址和请求结果（耗时、状态码、错误）
+	// 使用场景：请求处理完成、调用Done之前调用，用于peak-ewma等需要感知延迟和错误的算法
+	// Done只能表示"请求结束了"，Report补充了"请求结束得怎么样"
+	Report(string, Result)
+}
+
+// Result 是一次请求的结果，由代理在请求结束时通过Report报告给负载均衡器
+type Result struct {
+	// Duration 从开始转发到响应结束的耗时
+	Duration time.Duration
+
+	// StatusCode 返回给客户端的HTTP状态码
+	StatusCode int
+
+	// Err 转发过程中的错误，如连接被拒绝、超时
+	// 为nil表示后端正常返回了响应（即使是5xx）
+	Err error
+}
+
+// Failed 判断请求是否失败：转发出错，或者后端返回了5xx
+func (r Result) Failed() bool {
+	return r.Err != nil || r.StatusCode >= 500
 }
 
 // Factory 是创建Balancer的工厂函数类型
@@ -67,9 +99,94 @@ type Balancer interface {
 // 2. 便于扩展新的算法
 // 3. 统一的创建接口
 //
-// 参数：[]string 是初始的服务器地址列表
+// 参数：
+//   - []*Backend 是初始的后端服务器列表，带有权重等元数据
+//   - *Options 是算法的可选参数，Build保证不为nil
+//
 // 返回值：Balancer 接口的具体实现
-type Factory func([]string) Balancer
+type Factory func([]*Backend, *Options) Balancer
+
+// Backend 描述一个后端服务器
+// 工厂函数通过它拿到服务器地址之外的信息（如权重），
+// 创建完成后，Add/Remove/Balance仍然只使用服务器地址
+type Backend struct {
+	// Host 服务器地址，与Add/Remove/Balance使用的地址相同
+	Host string
+
+	// Weight 服务器权重，只有支持权重的算法会使用
+	// 小于等于0时按1处理
+	Weight int
+
+	// Labels 服务器的标签，如 ZoneLabel、RegionLabel
+	Labels map[string]string
+}
+
+// Options 是创建负载均衡器时的可选参数
+// 零值表示使用各算法自己的默认值，算法只读取自己关心的字段
+type Options struct {
+	// Replicas 一致性哈希类算法中每个服务器的虚拟节点数
+	Replicas int
+
+	// Hash 哈希类算法使用的哈希函数
+	Hash HashFunc
+
+	// LoadFactor 有界负载一致性哈希的负载因子
+	LoadFactor float64
+
+	// TableSize Maglev查找表的大小
+	TableSize int
+
+	// SlowStartWindow 慢启动的时长，0表示不开启慢启动
+	// 只对支持权重的算法（weighted-round-robin、rendezvous）生效
+	SlowStartWindow time.Duration
+
+	// SlowStartAggression 慢启动期间权重增长曲线的指数，1表示线性增长
+	SlowStartAggression float64
+
+	// Zone 负载均衡器自己所在的区域，不为空时开启区域感知路由
+	// 请求优先发给zone标签与它相同的服务器
+	Zone string
+
+	// OverprovisioningFactor 区域感知路由的超额配置系数
+	OverprovisioningFactor float64
+
+	// InstanceID 和 InstanceCount 标识负载均衡器实例，InstanceCount 大于1时开启确定性子集
+	// InstanceID 的范围是 [0, InstanceCount)
+	InstanceID    int
+	InstanceCount int
+
+	// SubsetSize 每个实例使用的服务器数，0表示按 服务器数 / InstanceCount 向上取整
+	SubsetSize int
+
+	// Seed 随机类算法（random、p2c、peak-ewma）和区域感知路由的随机数种子，0表示使用当前时间
+	// 相同的种子和相同的调用顺序得到相同的选择结果，用于模拟和测试
+	Seed int64
+}
+
+// seed 返回随机数种子，没有指定种子时使用当前时间
+func (o *Options) seed() int64 {
+	if o != nil && o.Seed != 0 {
+		return o.Seed
+	}
+	return time.Now().UnixNano()
+}
+
+// hostsOf 提取后端服务器的地址列表
+func hostsOf(backends []*Backend) []string {
+	hosts := make([]string, 0, len(backends))
+	for _, b := range backends {
+		hosts = append(hosts, b.Host)
+	}
+	return hosts
+}
+
+// hostsOnly 把只需要服务器地址的构造函数适配为Factory
+// 不关心权重和参数的算法（如ip-hash、round-robin）用它注册
+func hostsOnly(newBalancer func([]string) Balancer) Factory {
+	return func(backends []*Backend, _ *Options) Balancer {
+		return newBalancer(hostsOf(backends))
+	}
+}
 
 // factories 是算法名到工厂函数的映射表
 // 使用map存储所有注册的负载均衡算法
@@ -77,15 +194,80 @@ type Factory func([]string) Balancer
 // value: 对应的工厂函数
 //
 // 使用make初始化map，避免nil map panic
-// 这个map会在各个算法的init函数中填充
-var factories = make(map[string]Factory)
+// 内置算法在各自的init函数中通过MustRegister填充，
+// 包外的自定义算法通过Register填充，所以需要factoriesMu保护
+var (
+	factories   = make(map[string]Factory)
+	factoriesMu sync.RWMutex
+)
+
+// Register 注册一个负载均衡算法
+// 注册后，配置文件中的balance_mode就可以使用这个算法名称
+//
+// 参数：
+//   - name: 算法名称，不能为空，不能与已注册的算法重名
+//   - factory: 创建负载均衡器的工厂函数，不能为nil
+//
+// 通常在自定义算法所在包的init函数中调用，保证在读取配置文件之前完成注册：
+//
+//	func init() {
+//		balancer.MustRegister("my-algorithm", NewMyBalancer)
+//	}
+func Register(name string, factory Factory) error {
+	if name == "" {
+		return errors.New("algorithm name cannot be empty")
+	}
+	if factory == nil {
+		return fmt.Errorf("factory of algorithm \"%s\" cannot be nil", name)
+	}
+
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if _, ok := factories[name]; ok {
+		return fmt.Errorf("%w: %s", AlgorithmExistsError, name)
+	}
+	factories[name] = factory
+	return nil
+}
+
+// MustRegister 与Register相同，注册失败时panic
+// 用于init函数中，重名属于编程错误，应该在启动时立即暴露
+func MustRegister(name string, factory Factory) {
+	if err := Register(name, factory); err != nil {
+		panic(err)
+	}
+}
+
+// IsRegistered 判断算法名称是否已经注册
+func IsRegistered(name string) bool {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	_, ok := factories[name]
+	return ok
+}
+
+// Algorithms 返回所有已注册的算法名称，按字母顺序排列
+func Algorithms() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
 
 // Build 是负载均衡器的构建函数（Builder Pattern）
 // 根据算法名称和服务器列表创建相应的负载均衡器实例
 //
 // 参数：
 //   - algorithm: 算法名称，如 "round-robin"、"random"、"ip-hash" 等
-//   - hosts: 初始的后端服务器地址列表
+//   - backends: 初始的后端服务器列表
+//   - opts: 算法的可选参数，可以为nil
 //
 // 返回值：
 //   - Balancer: 负载均衡器接口实例
@@ -98,10 +280,12 @@ var factories = make(map[string]Factory)
 //
 // 这种设计允许在运行时动态选择算法，实现了开闭原则：
 // 对扩展开放（可以添加新算法），对修改关闭（不需要修改Build函数）
-func Build(algorithm string, hosts []string) (Balancer, error) {
+func Build(algorithm string, backends []*Backend, opts *Options) (Balancer, error) {
 	// 从注册表中查找工厂函数
 	// map的两值返回：value和是否存在
+	factoriesMu.RLock()
 	factory, ok := factories[algorithm]
+	factoriesMu.RUnlock()
 	if !ok {
 		// 算法未注册，返回错误
 		// 返回nil接口和错误是Go的惯用模式
@@ -109,5 +293,17 @@ func Build(algorithm string, hosts []string) (Balancer, error) {
 	}
 	// 调用工厂函数创建负载均衡器实例
 	// 工厂函数负责具体的初始化逻辑
-	return factory(hosts), nil
+	if opts == nil {
+		opts = &Options{}
+	}
+	// 开启确定性子集时，内层负载均衡器只使用本实例的子集
+	// 与区域感知路由同时开启时，每个区域各自计算子集
+	if opts.InstanceCount > 1 {
+		factory = subsetFactory(factory)
+	}
+	// 开启区域感知路由时，每个区域各自创建一个该算法的负载均衡器
+	if opts.Zone != "" {
+		return newZoneAware(factory, backends, opts), nil
+	}
+	return factory(backends, opts), nil
 }
@@ -0,0 +1,42 @@
+package balancer
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestBuildSeedReproducible 指定种子时，随机类算法和区域感知路由的选择序列可以复现
+func TestBuildSeedReproducible(t *testing.T) {
+	backends := make([]*Backend, 0, 6)
+	for i, host := range testHosts(6) {
+		zone := "a"
+		if i%2 == 1 {
+			zone = "b"
+		}
+		backends = append(backends, &Backend{Host: host, Weight: 1, Labels: map[string]string{ZoneLabel: zone}})
+	}
+
+	picks := func(algorithm string, opts Options) []string {
+		lb, err := Build(algorithm, backends, &opts)
+		if err != nil {
+			t.Fatalf("Build(%s): %v", algorithm, err)
+		}
+		lb.Remove(backends[0].Host)
+		hosts := make([]string, 100)
+		for i := range hosts {
+			if hosts[i], err = lb.Balance(""); err != nil {
+				t.Fatalf("Balance: %v", err)
+			}
+		}
+		return hosts
+	}
+
+	for _, algorithm := range []string{RandomBalancer, P2CBalancer, PeakEWMABalancer} {
+		for _, zone := range []string{"", "a"} {
+			opts := Options{Seed: 42, Zone: zone}
+			if !reflect.DeepEqual(picks(algorithm, opts), picks(algorithm, opts)) {
+				t.Errorf("%s (zone %q): same seed produced different picks", algorithm, zone)
+			}
+		}
+	}
+}
@@ -106,6 +106,17 @@ func (b *BaseBalancer) Remove(host string) {
 	// 这也是幂等性设计：移除不存在的主机不算错误
 }
 
+// has 判断主机是否在负载均衡池中
+// 调用者必须已经持有读锁或写锁
+func (b *BaseBalancer) has(host string) bool {
+	for _, h := range b.hosts {
+		if h == host {
+			return true
+		}
+	}
+	return false
+}
+
 // Balance 是负载均衡选择方法的默认实现
 // 参数 key：用于某些算法的键值（如客户端IP）
 // 返回值：选中的服务器地址和可能的错误
@@ -145,6 +156,10 @@ func (b *BaseBalancer) Inc(_ string) {}
 // 3. 保持接口签名的一致性
 func (b *BaseBalancer) Done(_ string) {}
 
+// Report 接收请求结果
+// 空实现，只有需要感知延迟和错误的算法（如peak-ewma）才重写这个方法
+func (b *BaseBalancer) Report(_ string, _ Result) {}
+
 // BaseBalancer的设计模式总结：
 // 1. 模板方法模式：提供算法框架，子类实现具体步骤
 // 2. 组合优于继承：通过嵌入实现代码复用
@@ -0,0 +1,168 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// 有界负载一致性哈希算法实现
+// 在一致性哈希的基础上给每个服务器设置负载上限
+package balancer
+
+import (
+	"math"
+)
+
+// init函数在包被导入时自动执行
+// 注册有界负载一致性哈希算法到工厂映射表
+func init() {
+	MustRegister(BoundedBalancer, func(backends []*Backend, opts *Options) Balancer {
+		return NewBoundedWith(hostsOf(backends), opts.Replicas, opts.Hash, opts.LoadFactor)
+	})
+}
+
+// DefaultLoadFactor 是默认的负载因子
+// 每个服务器的负载上限为平均负载的1.25倍，这是论文中推荐的取值
+const DefaultLoadFactor = 1.25
+
+// Bounded 有界负载一致性哈希负载均衡器结构体
+// 实现了Google的 "Consistent Hashing with Bounded Loads"（Mirrokni等，2016）
+//
+// 算法原理：
+//  1. 负载上限 capacity = ceil(loadFactor * (totalLoad + 1) / n)，totalLoad是存活服务器的活跃请求数之和
+//     +1是把本次请求也算进去
+//  2. 和一致性哈希一样，从key在环上的位置开始顺时针查找
+//  3. 如果虚拟节点所属的服务器负载已经达到上限，继续顺时针找下一个，直到找到未满的服务器
+//
+// loadFactor大于1保证了所有服务器的容量之和大于总负载，所以一定能找到未满的服务器。
+// 负载不高时，行为和普通一致性哈希相同；出现热点key时，溢出的请求会分散到环上的后续服务器
+type Bounded struct {
+	ConsistentHash
+
+	// loadFactor 负载因子，必须大于1
+	loadFactor float64
+
+	// loads 记录每个服务器的活跃请求数
+	// 与P2C相同，被移除的服务器的计数保留到在途请求全部Done
+	loads map[string]uint64
+
+	// total 是存活服务器的活跃请求数之和
+	total uint64
+}
+
+// NewBounded 使用默认参数创建有界负载一致性哈希负载均衡器
+func NewBounded(hosts []string) Balancer {
+	return NewBoundedWith(hosts, DefaultReplicas, FNV64aHash, DefaultLoadFactor)
+}
+
+// NewBoundedWith 使用指定的虚拟节点数、哈希函数和负载因子创建负载均衡器
+// loadFactor 小于等于1时使用DefaultLoadFactor
+func NewBoundedWith(hosts []string, replicas int, hash HashFunc, loadFactor float64) Balancer {
+	if loadFactor <= 1 {
+		loadFactor = DefaultLoadFactor
+	}
+	b := &Bounded{
+		ConsistentHash: ConsistentHash{
+			BaseBalancer: BaseBalancer{
+				hosts: hosts,
+			},
+			ring: newHashRing(replicas, hash),
+		},
+		loadFactor: loadFactor,
+		loads:      make(map[string]uint64),
+	}
+	b.ring.build(hosts)
+	return b
+}
+
+// Add 添加服务器并重建哈希环
+// 如果服务器在被移除期间还有在途请求，把这些请求重新计入总负载
+//
+// 没有复用ConsistentHash.Add，是因为判断服务器是否存在、修改hosts和修改total
+// 必须在同一次加锁中完成，否则并发的Add/Remove会让total算错
+func (b *Bounded) Add(host string) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.has(host) {
+		return
+	}
+	b.hosts = append(b.hosts, host)
+	b.ring.build(b.hosts)
+	b.total += b.loads[host]
+}
+
+// Remove 移除服务器并重建哈希环
+// 服务器的在途请求不再计入总负载，计数保留到请求全部Done
+func (b *Bounded) Remove(host string) {
+	b.Lock()
+	defer b.Unlock()
+
+	for i, h := range b.hosts {
+		if h != host {
+			continue
+		}
+		b.hosts = append(b.hosts[:i], b.hosts[i+1:]...)
+		b.ring.build(b.hosts)
+		b.total -= b.loads[host]
+		if b.loads[host] == 0 {
+			delete(b.loads, host)
+		}
+		return
+	}
+}
+
+// Balance 从key在环上的位置开始，顺时针返回第一个负载未达上限的服务器
+func (b *Bounded) Balance(key string) (string, error) {
+	b.RLock()
+	defer b.RUnlock()
+
+	n := len(b.ring.points)
+	if n == 0 {
+		return "", NoHostError
+	}
+
+	capacity := b.capacity()
+	start := b.ring.search(key)
+	for k := 0; k < n; k++ {
+		host := b.ring.owner((start + k) % n)
+		if b.loads[host]+1 <= capacity {
+			return host, nil
+		}
+	}
+	// loadFactor大于1时不会走到这里，保险起见退回普通一致性哈希的结果
+	return b.ring.owner(start), nil
+}
+
+// capacity 计算每个服务器的负载上限
+// 调用者必须已经持有读锁或写锁
+func (b *Bounded) capacity() uint64 {
+	avg := float64(b.total+1) / float64(len(b.hosts))
+	return uint64(math.Ceil(avg * b.loadFactor))
+}
+
+// Inc 增加服务器的活跃请求数
+func (b *Bounded) Inc(host string) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.loads[host]++
+	if b.has(host) {
+		b.total++
+	}
+}
+
+// Done 减少服务器的活跃请求数
+func (b *Bounded) Done(host string) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.loads[host] == 0 {
+		return
+	}
+	b.loads[host]--
+
+	alive := b.has(host)
+	if alive {
+		b.total--
+	} else if b.loads[host] == 0 {
+		delete(b.loads, host)
+	}
+}
@@ -0,0 +1,120 @@
+package balancer
+
+import (
+	"math"
+	"testing"
+)
+
+// clockwise 返回从key在环上的位置开始，顺时针依次遇到的服务器，每台服务器只出现一次
+func clockwise(b *Bounded, key string) []string {
+	var hosts []string
+	seen := make(map[string]bool)
+	n := len(b.ring.points)
+	start := b.ring.search(key)
+	for k := 0; k < n; k++ {
+		host := b.ring.owner((start + k) % n)
+		if !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// TestBoundedHotKeySpillsClockwise 同一个key的请求一直分配给它在环上的服务器，
+// 直到这台服务器的负载达到 ceil(c*(total+1)/n)，之后溢出到顺时针方向下一台未满的服务器
+func TestBoundedHotKeySpillsClockwise(t *testing.T) {
+	const key = "hot"
+	hosts := testHosts(4)
+	b := NewBoundedWith(append([]string(nil), hosts...), DefaultReplicas, FNV64aHash, DefaultLoadFactor).(*Bounded)
+	order := clockwise(b, key)
+	if len(order) != len(hosts) {
+		t.Fatalf("ring has %d hosts, want %d", len(order), len(hosts))
+	}
+
+	loads := make(map[string]uint64)
+	spilled := false
+	for total := 0; total < 40; total++ {
+		capacity := uint64(math.Ceil(DefaultLoadFactor * float64(total+1) / float64(len(hosts))))
+		want := ""
+		for _, host := range order {
+			if loads[host]+1 <= capacity {
+				want = host
+				break
+			}
+		}
+		if want != order[0] {
+			if loads[order[0]] != capacity {
+				t.Fatalf("request %d spilled while owner %s has load %d < capacity %d",
+					total, order[0], loads[order[0]], capacity)
+			}
+			spilled = true
+		}
+
+		host, err := b.Balance(key)
+		if err != nil {
+			t.Fatalf("Balance: %v", err)
+		}
+		if host != want {
+			t.Fatalf("request %d: Balance = %s, want %s (loads %v, capacity %d)", total, host, want, loads, capacity)
+		}
+		b.Inc(host)
+		loads[host]++
+	}
+	if !spilled {
+		t.Fatal("hot key never spilled to the next host")
+	}
+	limit := uint64(math.Ceil(DefaultLoadFactor * 40 / float64(len(hosts))))
+	for host, load := range loads {
+		if load > limit {
+			t.Errorf("%s has load %d, above the bound %d", host, load, limit)
+		}
+	}
+}
+
+// TestBoundedTotalWithInflight 服务器在有在途请求时被移除再加回，所有请求Done之后total回到0
+func TestBoundedTotalWithInflight(t *testing.T) {
+	b := NewBounded([]string{"a", "b", "c"}).(*Bounded)
+	wantTotal := func(step string, want uint64) {
+		t.Helper()
+		if b.total != want {
+			t.Fatalf("%s: total = %d, want %d", step, b.total, want)
+		}
+	}
+
+	b.Inc("a")
+	b.Inc("a")
+	b.Inc("b")
+	wantTotal("after Inc", 3)
+
+	b.Remove("a")
+	wantTotal("after Remove a", 1)
+	b.Done("a")
+	wantTotal("after Done a while removed", 1)
+
+	b.Add("a")
+	wantTotal("after Add a", 2)
+	b.Inc("a")
+	b.Remove("b")
+	wantTotal("after Remove b", 2)
+	b.Remove("b")
+	wantTotal("after removing b twice", 2)
+
+	b.Done("a")
+	b.Done("a")
+	b.Done("b")
+	wantTotal("after all Done", 0)
+	b.Add("b")
+	wantTotal("after Add b", 0)
+	b.Done("b")
+	wantTotal("after extra Done", 0)
+
+	for host, load := range b.loads {
+		if load != 0 {
+			t.Errorf("%s has load %d after all requests are done", host, load)
+		}
+	}
+	if _, ok := b.loads["b"]; ok {
+		t.Error("load of b not released after its requests finished while removed")
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// 一致性哈希负载均衡算法实现
+// 将服务器和请求都映射到哈希环上，请求分配给顺时针方向最近的服务器
+package balancer
+
+import (
+	"errors"
+	"hash/crc32"
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// init函数在包被导入时自动执行
+// 注册一致性哈希算法到工厂映射表
+func init() {
+	MustRegister(ConsistentHashBalancer, func(backends []*Backend, opts *Options) Balancer {
+		return NewConsistentHashWith(hostsOf(backends), opts.Replicas, opts.Hash)
+	})
+}
+
+// DefaultReplicas 是每个服务器默认的虚拟节点数
+// 与ketama/nginx的默认值相同，在几十台服务器的规模下分布已经比较均匀
+const DefaultReplicas = 160
+
+// HashFunc 是哈希环使用的哈希函数
+// 返回uint64，32位哈希函数的结果直接扩展为uint64即可
+type HashFunc func([]byte) uint64
+
+// CRC32Hash 使用IEEE多项式的CRC32哈希，与IPHash使用的哈希相同
+// CRC32是线性的，host#0 ... host#n 这类只有末尾不同的输入得到的虚拟节点会在环上扎堆，
+// 分布明显不如FNV64aHash均匀，只在需要与旧的CRC32环保持相同映射时通过配置选择
+func CRC32Hash(data []byte) uint64 {
+	return uint64(crc32.ChecksumIEEE(data))
+}
+
+// FNV64aHash 使用64位FNV-1a哈希
+// 64位的取值空间让虚拟节点之间几乎不会冲突
+// FNV-1a对只有末尾几个字符不同的key（如 host#1、host#2）高位变化很小，
+// 所以最后用murmur3的fmix64再混合一次，让结果在整个环上均匀分布
+func FNV64aHash(data []byte) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write(data)
+	return mix64(h.Sum64())
+}
+
+// mix64 是murmur3的fmix64，把输入的每一位均匀地扩散到输出的所有位上
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// HashFuncNotSupportedError 表示配置的哈希函数名称不支持
+var HashFuncNotSupportedError = errors.New("hash function not supported")
+
+// hashFuncs 是哈希函数名称到实现的映射，用于从配置文件中选择哈希函数
+var hashFuncs = map[string]HashFunc{
+	"crc32":  CRC32Hash,
+	"fnv64a": FNV64aHash,
+}
+
+// GetHashFunc 根据名称返回哈希函数
+// 名称为空时返回nil，由各算法使用自己的默认哈希函数
+func GetHashFunc(name string) (HashFunc, error) {
+	if name == "" {
+		return nil, nil
+	}
+	hash, ok := hashFuncs[name]
+	if !ok {
+		return nil, HashFuncNotSupportedError
+	}
+	return hash, nil
+}
+
+// hashRing 哈希环
+// 不是并发安全的，由使用它的负载均衡器加锁保护
+type hashRing struct {
+	// replicas 每个服务器在环上的虚拟节点数
+	replicas int
+	hash     HashFunc
+
+	// points 是所有虚拟节点的哈希值，升序排列
+	points []uint64
+	// owners 记录每个虚拟节点属于哪个服务器
+	owners map[uint64]string
+}
+
+func newHashRing(replicas int, hash HashFunc) *hashRing {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	if hash == nil {
+		hash = FNV64aHash
+	}
+	return &hashRing{
+		replicas: replicas,
+		hash:     hash,
+		owners:   make(map[uint64]string),
+	}
+}
+
+// build 根据服务器列表重建哈希环
+//
+// 每次都整体重建而不是增量修改，原因：
+// 1. 虚拟节点哈希冲突时固定保留字典序较小的服务器，环的结构只取决于服务器集合
+// 2. 重建只发生在健康检查改变服务器状态时，频率很低
+func (r *hashRing) build(hosts []string) {
+	owners := make(map[uint64]string, len(hosts)*r.replicas)
+	for _, host := range hosts {
+		for i := 0; i < r.replicas; i++ {
+			point := r.hash([]byte(host + "#" + strconv.Itoa(i)))
+			if owner, ok := owners[point]; ok && owner < host {
+				continue
+			}
+			owners[point] = host
+		}
+	}
+
+	points := make([]uint64, 0, len(owners))
+	for point := range owners {
+		points = append(points, point)
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i] < points[j] })
+
+	r.points = points
+	r.owners = owners
+}
+
+// search 返回key在环上顺时针方向第一个虚拟节点的下标
+// 调用者需要保证环不为空
+func (r *hashRing) search(key string) int {
+	h := r.hash([]byte(key))
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		// 超过最大的虚拟节点，回到环的起点
+		i = 0
+	}
+	return i
+}
+
+// owner 返回下标为i的虚拟节点所属的服务器
+func (r *hashRing) owner(i int) string {
+	return r.owners[r.points[i]]
+}
+
+// ConsistentHash 一致性哈希负载均衡器结构体
+//
+// 算法原理：
+// 1. 每个服务器按 host#0 ... host#(replicas-1) 计算哈希，得到环上的多个虚拟节点
+// 2. 请求的key计算哈希后，沿环顺时针找到第一个虚拟节点，交给它所属的服务器
+//
+// 与IPHash取模的区别：
+// 移除一台服务器时，只有原本落在这台服务器上的key（约1/N）会重新分配，
+// 其余key的归属不变，适合缓存这类对key归属敏感的场景
+type ConsistentHash struct {
+	BaseBalancer
+	ring *hashRing
+}
+
+// NewConsistentHash 使用默认虚拟节点数和FNV64a哈希创建一致性哈希负载均衡器
+func NewConsistentHash(hosts []string) Balancer {
+	return NewConsistentHashWith(hosts, DefaultReplicas, FNV64aHash)
+}
+
+// NewConsistentHashWith 使用指定的虚拟节点数和哈希函数创建一致性哈希负载均衡器
+// replicas 小于等于0时使用DefaultReplicas，hash 为nil时使用FNV64aHash
+func NewConsistentHashWith(hosts []string, replicas int, hash HashFunc) Balancer {
+	c := &ConsistentHash{
+		BaseBalancer: BaseBalancer{
+			hosts: hosts,
+		},
+		ring: newHashRing(replicas, hash),
+	}
+	c.ring.build(hosts)
+	return c
+}
+
+// Add 添加服务器并重建哈希环
+func (c *ConsistentHash) Add(host string) {
+	c.BaseBalancer.Add(host)
+
+	c.Lock()
+	defer c.Unlock()
+	c.ring.build(c.hosts)
+}
+
+// Remove 移除服务器并重建哈希环
+func (c *ConsistentHash) Remove(host string) {
+	c.BaseBalancer.Remove(host)
+
+	c.Lock()
+	defer c.Unlock()
+	c.ring.build(c.hosts)
+}
+
+// Balance 返回key在哈希环上顺时针方向最近的服务器
+func (c *ConsistentHash) Balance(key string) (string, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	if len(c.ring.points) == 0 {
+		return "", NoHostError
+	}
+	return c.ring.owner(c.ring.search(key)), nil
+}
@@ -0,0 +1,96 @@
+package balancer
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func testHosts(n int) []string {
+	hosts := make([]string, n)
+	for i := range hosts {
+		hosts[i] = fmt.Sprintf("10.0.0.%d:8080", i+1)
+	}
+	return hosts
+}
+
+func testKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("192.168.%d.%d", i/256, i%256)
+	}
+	return keys
+}
+
+// owners 返回每个key被分配到的服务器
+func owners(t *testing.T, lb Balancer, keys []string) map[string]string {
+	t.Helper()
+	m := make(map[string]string, len(keys))
+	for _, key := range keys {
+		host, err := lb.Balance(key)
+		if err != nil {
+			t.Fatalf("Balance(%q): %v", key, err)
+		}
+		m[key] = host
+	}
+	return m
+}
+
+// TestConsistentHashRemoveMovesOnlyItsKeys 移除一台服务器，只有原本属于它的key改变归属
+func TestConsistentHashRemoveMovesOnlyItsKeys(t *testing.T) {
+	hosts := testHosts(10)
+	keys := testKeys(10000)
+	lb := NewConsistentHash(append([]string(nil), hosts...))
+
+	before := owners(t, lb, keys)
+	removed := hosts[3]
+	lb.Remove(removed)
+	after := owners(t, lb, keys)
+
+	moved := 0
+	for _, key := range keys {
+		if before[key] == after[key] {
+			continue
+		}
+		if before[key] != removed {
+			t.Fatalf("key %s moved from %s to %s, but only keys of %s should move",
+				key, before[key], after[key], removed)
+		}
+		moved++
+	}
+	for key, host := range after {
+		if host == removed {
+			t.Fatalf("key %s is still owned by removed host %s", key, host)
+		}
+	}
+	if moved == 0 {
+		t.Fatalf("no key was owned by %s", removed)
+	}
+}
+
+// TestConsistentHashDefaultDistribution 默认哈希函数下，每台服务器分到的key接近平均值
+func TestConsistentHashDefaultDistribution(t *testing.T) {
+	hosts := testHosts(10)
+	keys := testKeys(100000)
+	lb := NewConsistentHash(hosts)
+
+	counts := make(map[string]int)
+	for _, host := range owners(t, lb, keys) {
+		counts[host]++
+	}
+
+	mean := float64(len(keys)) / float64(len(hosts))
+	sum, squares, busiest := 0.0, 0.0, 0.0
+	for _, host := range hosts {
+		c := float64(counts[host])
+		sum += c
+		squares += c * c
+		busiest = math.Max(busiest, c)
+	}
+	// Jain公平性指数，1表示完全均匀
+	fairness := sum * sum / (float64(len(hosts)) * squares)
+	if busiest > 1.3*mean || fairness < 0.95 {
+		t.Errorf("uneven distribution: busiest %.2fx of mean, fairness %.3f, counts %v",
+			busiest/mean, fairness, counts)
+	}
+}
@@ -58,7 +58,7 @@ const (
 	// 优点：考虑服务器实时负载，分配更合理
 	// 缺点：需要维护连接数状态，实现较复杂
 	// 使用场景：请求处理时间差异大，服务器性能不同
-	// 实现说明：使用斐波那契堆实现，查找最小值O(1)，插入删除O(log n)
+	// 实现说明：使用二叉最小堆实现，查找最小值O(1)，插入删除和更新负载O(log n)
 	LeastLoadBalancer = "least-load"
 
 	// BoundedBalancer 有界一致性哈希算法
@@ -68,12 +68,51 @@ const (
 	// 使用场景：需要一致性哈希特性，同时要避免热点问题
 	// 算法说明：当某个服务器负载过高时，请求会分配给其他服务器
 	BoundedBalancer = "bounded"
+
+	// WeightedRoundRobinBalancer 平滑加权轮询算法
+	// 按照服务器权重分配请求，权重为3的服务器收到的请求是权重为1的服务器的3倍
+	// 优点：考虑服务器处理能力差异，且同一服务器的请求在时间上是分散的
+	// 缺点：权重需要人工配置，不能反映实时负载
+	// 使用场景：服务器配置不同（如4核和16核机器混合部署）
+	// 算法说明：与nginx的smooth weighted round-robin相同
+	WeightedRoundRobinBalancer = "weighted-round-robin"
+
+	// PeakEWMABalancer Peak EWMA（峰值指数加权移动平均）算法
+	// 用衰减后的响应延迟乘以活跃请求数作为负载，随机选两个服务器取负载较小的
+	// 优点：能感知后端变慢，自动避开GC、过载的服务器
+	// 缺点：需要代理在请求结束时报告耗时和结果（Report）
+	// 使用场景：后端延迟波动大，或者服务器性能不一致
+	// 算法来源：Twitter Finagle，Linkerd也使用该算法
+	PeakEWMABalancer = "peak-ewma"
+
+	// MaglevBalancer Maglev一致性哈希算法
+	// 所有服务器按各自的排列轮流填充一张固定大小的查找表，请求的key哈希后直接查表
+	// 优点：选择是O(1)的，各服务器分到的key数量几乎相同，服务器变化时只有少量key重新分配
+	// 缺点：查找表占用内存，服务器变化时需要重建整张表
+	// 使用场景：需要会话保持，同时服务器会因为健康检查上下线
+	// 算法来源：Google Maglev 论文
+	MaglevBalancer = "maglev"
+
+	// RendezvousBalancer Rendezvous哈希（最高随机权重，HRW）算法
+	// 对每个服务器计算 key 的加权得分，选择得分最高的服务器
+	// 优点：没有哈希环或查找表，天然支持权重，移除服务器时只有原本选中它的key会重新分配
+	// 缺点：每次选择都要计算所有服务器的得分，时间复杂度O(n)
+	// 使用场景：服务器数量不多、又经常因为健康检查上下线的会话保持场景
+	RendezvousBalancer = "rendezvous"
+
+	// JumpHashBalancer Jump一致性哈希算法
+	// 把key映射为分片编号，分片编号就是服务器在proxy_pass中的顺序
+	// 优点：不需要任何额外内存，各分片分到的key数量几乎相同，追加分片时只有 1/n 的key移动
+	// 缺点：分片只能追加，不支持权重，不可用的分片上的key需要重新哈希到其他分片
+	// 使用场景：分片存储，proxy_pass的顺序与存储的分片编号一致
+	// 算法来源：Google "A Fast, Minimal Memory, Consistent Hash Algorithm"
+	JumpHashBalancer = "jump-hash"
 )
 
 // 算法选择建议：
-// 1. 简单场景：round-robin 或 random
-// 2. 需要会话保持：ip-hash
-// 3. 分布式缓存：consistent-hash
+// 1. 简单场景：round-robin 或 random，服务器配置不同时用 weighted-round-robin
+// 2. 需要会话保持：服务器数量少时用 rendezvous，数量多时用 maglev，ip-hash 适合服务器固定的场景
+// 3. 分布式缓存：consistent-hash，分片存储：jump-hash
 // 4. 高性能要求：p2c
-// 5. 负载差异大：least-load
+// 5. 负载差异大：least-load，延迟波动大：peak-ewma
 // 6. 综合场景：bounded
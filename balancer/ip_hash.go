@@ -18,8 +18,8 @@ import (
 func init() {
 	// 将IP哈希算法注册到全局工厂映射表
 	// IPHashBalancer 是算法名称常量
-	// NewIPHash 是创建IPHash负载均衡器的工厂函数
-	factories[IPHashBalancer] = NewIPHash
+	// NewIPHash 是创建IPHash负载均衡器的构造函数，ip-hash不关心权重，用hostsOnly适配
+	MustRegister(IPHashBalancer, hostsOnly(NewIPHash))
 }
 
 // IPHash IP哈希负载均衡器结构体
@@ -49,7 +49,7 @@ type IPHash struct {
 }
 
 // NewIPHash 创建一个新的IP哈希负载均衡器
-// 这是工厂函数，实现了Factory函数签名
+// 通过hostsOnly适配为Factory函数签名
 //
 // 参数 hosts：初始的服务器地址列表
 // 返回值：实现了Balancer接口的IPHash实例
@@ -0,0 +1,133 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Jump一致性哈希负载均衡算法实现
+// 把key映射为分片编号，分片编号就是服务器在proxy_pass中的顺序
+package balancer
+
+import (
+	"sync"
+)
+
+// init函数在包被导入时自动执行
+// 注册jump-hash算法到工厂映射表
+func init() {
+	MustRegister(JumpHashBalancer, func(backends []*Backend, opts *Options) Balancer {
+		return NewJumpHashWith(hostsOf(backends), opts.Hash)
+	})
+}
+
+// maxJumpAttempts 是key选中的分片不可用时，重新哈希的最多次数
+// 超过次数后从该分片开始顺序查找下一个可用的分片
+const maxJumpAttempts = 32
+
+// JumpHash Jump一致性哈希负载均衡器结构体
+//
+// 算法原理（Google Jump Consistent Hash）：
+// 1. 用key的哈希值作为线性同余随机数的种子，模拟分片数从1增加到n时key“跳”到新分片的过程
+// 2. 最后一次跳到的位置就是key的分片，分片数从n增加到n+1时只有 1/(n+1) 的key会移动
+//
+// 特点：
+// 1. 除了分片列表外不需要任何内存，没有哈希环或查找表
+// 2. 分片只能追加：Add未出现过的服务器时追加为最后一个分片，Remove只把分片标记为不可用
+// 3. key选中的分片不可用时用 key的哈希值+尝试次数 重新计算，只有落在不可用分片上的key会改选
+type JumpHash struct {
+	sync.RWMutex
+
+	// shards 按分片编号排列的服务器，只追加不删除
+	shards []string
+	// index 记录服务器的分片编号
+	index map[string]int
+	// dead 记录不可用的分片
+	dead map[int]bool
+
+	hash HashFunc
+}
+
+// NewJumpHash 使用默认的哈希函数创建jump一致性哈希负载均衡器
+func NewJumpHash(hosts []string) Balancer {
+	return NewJumpHashWith(hosts, nil)
+}
+
+// NewJumpHashWith 使用指定的哈希函数创建jump一致性哈希负载均衡器
+// hosts 的顺序就是分片编号，重复的服务器只保留第一次出现的位置
+// hash 为nil时使用FNV64aHash
+func NewJumpHashWith(hosts []string, hash HashFunc) Balancer {
+	if hash == nil {
+		hash = FNV64aHash
+	}
+	j := &JumpHash{
+		index: make(map[string]int),
+		dead:  make(map[int]bool),
+		hash:  hash,
+	}
+	for _, host := range hosts {
+		j.Add(host)
+	}
+	return j
+}
+
+// Add 服务器已经是一个分片时恢复为可用，否则追加为新的分片
+func (j *JumpHash) Add(host string) {
+	j.Lock()
+	defer j.Unlock()
+
+	if i, ok := j.index[host]; ok {
+		delete(j.dead, i)
+		return
+	}
+	j.index[host] = len(j.shards)
+	j.shards = append(j.shards, host)
+}
+
+// Remove 把服务器所在的分片标记为不可用，分片编号保持不变
+func (j *JumpHash) Remove(host string) {
+	j.Lock()
+	defer j.Unlock()
+
+	if i, ok := j.index[host]; ok {
+		j.dead[i] = true
+	}
+}
+
+// Balance 返回key所在分片的服务器，分片不可用时选择下一个可用的分片
+func (j *JumpHash) Balance(key string) (string, error) {
+	j.RLock()
+	defer j.RUnlock()
+
+	n := len(j.shards)
+	if len(j.dead) >= n {
+		return "", NoHostError
+	}
+
+	h := j.hash([]byte(key))
+	shard := jumpHash(h, n)
+	for attempt := uint64(1); j.dead[shard] && attempt <= maxJumpAttempts; attempt++ {
+		shard = jumpHash(mix64(h+attempt), n)
+	}
+	for j.dead[shard] {
+		shard = (shard + 1) % n
+	}
+	return j.shards[shard], nil
+}
+
+// jumpHash 是论文中的Jump Consistent Hash函数，返回key在n个分片中的编号
+func jumpHash(key uint64, n int) int {
+	b, next := int64(-1), int64(0)
+	for next < int64(n) {
+		b = next
+		key = key*2862933555777941757 + 1
+		next = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// Inc jump-hash不需要追踪连接数
+func (j *JumpHash) Inc(_ string) {}
+
+// Done jump-hash不需要追踪连接数
+func (j *JumpHash) Done(_ string) {}
+
+// Report jump-hash不需要请求结果
+func (j *JumpHash) Report(_ string, _ Result) {}
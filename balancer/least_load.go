@@ -0,0 +1,177 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// 最少负载均衡算法实现
+// 选择当前活跃请求数最少的服务器
+package balancer
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// init函数在包被导入时自动执行
+// 注册最少负载算法到工厂映射表
+func init() {
+	MustRegister(LeastLoadBalancer, hostsOnly(NewLeastLoad))
+}
+
+// leastLoadItem 是最小堆中的一个元素，对应一个服务器
+type leastLoadItem struct {
+	host string
+	// load 是服务器的活跃请求数
+	load uint64
+	// index 是元素在堆中的下标，由heap.Interface维护
+	// -1 表示服务器已被移除，但还有在途请求没有Done
+	index int
+}
+
+// leastLoadHeap 按活跃请求数排序的最小堆
+// 实现了container/heap的heap.Interface接口
+type leastLoadHeap []*leastLoadItem
+
+func (h leastLoadHeap) Len() int { return len(h) }
+
+func (h leastLoadHeap) Less(i, j int) bool { return h[i].load < h[j].load }
+
+func (h leastLoadHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *leastLoadHeap) Push(x any) {
+	item := x.(*leastLoadItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *leastLoadHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = -1
+	*h = old[:n-1]
+	return item
+}
+
+// LeastLoad 最少负载均衡器结构体
+//
+// 算法原理：
+// 用一个以活跃请求数为键的二叉最小堆维护所有存活的服务器，
+// 堆顶就是负载最小的服务器
+//
+// 时间复杂度：
+// - Balance：O(1)，直接读取堆顶
+// - Inc/Done：O(log n)，修改计数后调整元素在堆中的位置
+// - Add/Remove：O(log n)
+//
+// 负载计数的一致性：
+// 与P2C相同，被Remove的服务器会离开堆，但计数保留到在途请求全部Done，
+// 期间如果服务器被重新Add，会带着原来的计数回到堆中
+type LeastLoad struct {
+	sync.RWMutex
+
+	// heap 只包含存活的服务器
+	heap leastLoadHeap
+
+	// items 包含存活的服务器，以及已移除但还有在途请求的服务器
+	items map[string]*leastLoadItem
+}
+
+// NewLeastLoad 创建一个新的最少负载均衡器
+func NewLeastLoad(hosts []string) Balancer {
+	l := &LeastLoad{
+		items: make(map[string]*leastLoadItem),
+	}
+	for _, host := range hosts {
+		l.Add(host)
+	}
+	return l
+}
+
+// Add 将服务器放入堆中
+func (l *LeastLoad) Add(host string) {
+	l.Lock()
+	defer l.Unlock()
+
+	item, ok := l.items[host]
+	if !ok {
+		item = &leastLoadItem{host: host, index: -1}
+		l.items[host] = item
+	}
+	if item.index < 0 {
+		heap.Push(&l.heap, item)
+	}
+}
+
+// Remove 将服务器移出堆
+// 如果服务器还有在途请求，保留它的计数
+func (l *LeastLoad) Remove(host string) {
+	l.Lock()
+	defer l.Unlock()
+
+	item, ok := l.items[host]
+	if !ok {
+		return
+	}
+	if item.index >= 0 {
+		heap.Remove(&l.heap, item.index)
+	}
+	if item.load == 0 {
+		delete(l.items, host)
+	}
+}
+
+// Balance 返回活跃请求数最少的服务器
+// 参数 key 在最少负载算法中不使用
+func (l *LeastLoad) Balance(_ string) (string, error) {
+	l.RLock()
+	defer l.RUnlock()
+
+	if len(l.heap) == 0 {
+		return "", NoHostError
+	}
+	return l.heap[0].host, nil
+}
+
+// Inc 增加服务器的活跃请求数，并调整它在堆中的位置
+func (l *LeastLoad) Inc(host string) {
+	l.Lock()
+	defer l.Unlock()
+
+	item, ok := l.items[host]
+	if !ok {
+		// Balance和Inc之间服务器被移除了，仍然计数，保证与Done配对
+		item = &leastLoadItem{host: host, index: -1}
+		l.items[host] = item
+	}
+	item.load++
+	if item.index >= 0 {
+		heap.Fix(&l.heap, item.index)
+	}
+}
+
+// Done 减少服务器的活跃请求数，并调整它在堆中的位置
+func (l *LeastLoad) Done(host string) {
+	l.Lock()
+	defer l.Unlock()
+
+	item, ok := l.items[host]
+	if !ok {
+		return
+	}
+	if item.load > 0 {
+		item.load--
+	}
+	if item.index >= 0 {
+		heap.Fix(&l.heap, item.index)
+	} else if item.load == 0 {
+		delete(l.items, host)
+	}
+}
+
+// Report 最少负载算法只关心活跃请求数，不需要请求结果
+func (l *LeastLoad) Report(_ string, _ Result) {}
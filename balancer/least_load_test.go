@@ -0,0 +1,129 @@
+package balancer
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestLeastLoadPicksMinimum(t *testing.T) {
+	lb := NewLeastLoad([]string{"a", "b", "c"})
+	lb.Inc("a")
+	lb.Inc("a")
+	lb.Inc("b")
+
+	host, err := lb.Balance("")
+	if err != nil {
+		t.Fatalf("Balance: %v", err)
+	}
+	if host != "c" {
+		t.Fatalf("Balance = %s, want c", host)
+	}
+}
+
+// TestLeastLoadRemoveWithInflight 服务器在有在途请求时被移除再加回，计数应该回到0并且服务器留在堆中
+func TestLeastLoadRemoveWithInflight(t *testing.T) {
+	lb := NewLeastLoad([]string{"a", "b"}).(*LeastLoad)
+
+	lb.Inc("a")
+	lb.Remove("a")
+	lb.Add("a")
+	lb.Done("a")
+
+	item, ok := lb.items["a"]
+	if !ok {
+		t.Fatal("host a lost its counter")
+	}
+	if item.load != 0 {
+		t.Errorf("load of a = %d, want 0", item.load)
+	}
+	if item.index < 0 || lb.heap[item.index] != item {
+		t.Errorf("host a is not in the heap (index %d)", item.index)
+	}
+	if len(lb.heap) != 2 {
+		t.Errorf("heap has %d hosts, want 2", len(lb.heap))
+	}
+}
+
+// linearLeastLoad 是线性扫描所有服务器的最少负载实现，作为堆实现的基准
+type linearLeastLoad struct {
+	sync.Mutex
+	hosts []string
+	load  map[string]uint64
+}
+
+func newLinearLeastLoad(hosts []string) *linearLeastLoad {
+	return &linearLeastLoad{hosts: hosts, load: make(map[string]uint64)}
+}
+
+func (l *linearLeastLoad) Balance(_ string) (string, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	if len(l.hosts) == 0 {
+		return "", NoHostError
+	}
+	best := l.hosts[0]
+	for _, host := range l.hosts[1:] {
+		if l.load[host] < l.load[best] {
+			best = host
+		}
+	}
+	return best, nil
+}
+
+func (l *linearLeastLoad) Inc(host string) {
+	l.Lock()
+	l.load[host]++
+	l.Unlock()
+}
+
+func (l *linearLeastLoad) Done(host string) {
+	l.Lock()
+	l.load[host]--
+	l.Unlock()
+}
+
+// leastLoader 是基准测试需要的方法
+type leastLoader interface {
+	Balance(string) (string, error)
+	Inc(string)
+	Done(string)
+}
+
+func benchmarkHosts(n int) []string {
+	hosts := make([]string, n)
+	for i := range hosts {
+		hosts[i] = fmt.Sprintf("10.0.%d.%d:80", i/256, i%256)
+	}
+	return hosts
+}
+
+// benchmarkLeastLoad 每次迭代选择一个服务器并计数，保持每个服务器有一些在途请求，
+// 然后结束最早的一个请求，模拟代理中Balance、Inc、Done的调用顺序
+func benchmarkLeastLoad(b *testing.B, newBalancer func([]string) leastLoader) {
+	for _, n := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("hosts=%d", n), func(b *testing.B) {
+			lb := newBalancer(benchmarkHosts(n))
+			inflight := make([]string, 0, n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				host, _ := lb.Balance("")
+				lb.Inc(host)
+				inflight = append(inflight, host)
+				if len(inflight) == n {
+					lb.Done(inflight[0])
+					inflight = inflight[1:]
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkLeastLoadHeap(b *testing.B) {
+	benchmarkLeastLoad(b, func(hosts []string) leastLoader { return NewLeastLoad(hosts) })
+}
+
+func BenchmarkLeastLoadLinear(b *testing.B) {
+	benchmarkLeastLoad(b, func(hosts []string) leastLoader { return newLinearLeastLoad(hosts) })
+}
@@ -0,0 +1,196 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Maglev 一致性哈希负载均衡算法实现
+// 使用查找表实现O(1)的选择，服务器变化时只有少量key被重新分配
+package balancer
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// init函数在包被导入时自动执行
+// 注册maglev算法到工厂映射表
+func init() {
+	MustRegister(MaglevBalancer, func(backends []*Backend, opts *Options) Balancer {
+		return NewMaglevWith(hostsOf(backends), opts.TableSize, opts.Hash)
+	})
+}
+
+// DefaultTableSize 是Maglev查找表的默认大小
+// 查找表大小必须是质数，并且远大于服务器数量，论文建议至少是服务器数量的100倍
+const DefaultTableSize = 65537
+
+// maglevTable 是一次构建出的查找表，构建后不再修改
+type maglevTable struct {
+	// hosts 是构建查找表时的服务器列表，已排序
+	hosts []string
+	// entry 的每一项是hosts中的下标
+	entry []int32
+}
+
+// Maglev Maglev负载均衡器结构体
+// 实现了Google的 "Maglev: A Fast and Reliable Software Network Load Balancer"（2016）中的一致性哈希
+//
+// 算法原理：
+//  1. 每个服务器根据自己的名称计算 offset 和 skip，
+//     得到一个 [0, M) 的排列：permutation[j] = (offset + j*skip) mod M，M是质数
+//  2. 所有服务器轮流按自己的排列填表，遇到已被占用的位置就跳到排列的下一项，直到表被填满
+//  3. 选择时计算 hash(key) mod M，直接查表得到服务器，时间复杂度O(1)
+//
+// 服务器轮流填表保证了每个服务器占据的表项数几乎相同（相差不超过1）；
+// 服务器变化时大部分表项的归属保持不变，只有少量key被重新分配
+//
+// 并发设计（写时复制）：
+// Add/Remove在互斥锁保护下构建一张新表，然后用原子指针替换旧表；
+// Balance只做一次原子读取，永远不会被Add/Remove阻塞
+type Maglev struct {
+	// mu 只用于串行化Add/Remove，Balance不需要加锁
+	mu    sync.Mutex
+	hosts []string
+
+	size uint64
+	hash HashFunc
+
+	table atomic.Pointer[maglevTable]
+}
+
+// NewMaglev 使用默认的查找表大小创建Maglev负载均衡器
+func NewMaglev(hosts []string) Balancer {
+	return NewMaglevWith(hosts, DefaultTableSize, nil)
+}
+
+// NewMaglevWith 使用指定的查找表大小和哈希函数创建Maglev负载均衡器
+// size 会被调整为不小于它的质数，小于等于0时使用DefaultTableSize；hash 为nil时使用FNV64aHash
+func NewMaglevWith(hosts []string, size int, hash HashFunc) Balancer {
+	if size <= 0 {
+		size = DefaultTableSize
+	}
+	if hash == nil {
+		hash = FNV64aHash
+	}
+	m := &Maglev{
+		hosts: append([]string(nil), hosts...),
+		size:  nextPrime(uint64(size)),
+		hash:  hash,
+	}
+	m.rebuild()
+	return m
+}
+
+// Add 添加服务器并重建查找表
+func (m *Maglev) Add(host string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, h := range m.hosts {
+		if h == host {
+			return
+		}
+	}
+	m.hosts = append(m.hosts, host)
+	m.rebuild()
+}
+
+// Remove 移除服务器并重建查找表
+func (m *Maglev) Remove(host string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, h := range m.hosts {
+		if h == host {
+			m.hosts = append(m.hosts[:i], m.hosts[i+1:]...)
+			m.rebuild()
+			return
+		}
+	}
+}
+
+// Balance 查表返回key对应的服务器
+func (m *Maglev) Balance(key string) (string, error) {
+	t := m.table.Load()
+	if len(t.hosts) == 0 {
+		return "", NoHostError
+	}
+	return t.hosts[t.entry[m.hash([]byte(key))%m.size]], nil
+}
+
+// Inc Maglev不需要追踪连接数
+func (m *Maglev) Inc(_ string) {}
+
+// Done Maglev不需要追踪连接数
+func (m *Maglev) Done(_ string) {}
+
+// Report Maglev不需要请求结果
+func (m *Maglev) Report(_ string, _ Result) {}
+
+// rebuild 根据当前服务器列表构建新的查找表并替换旧表
+// 调用者必须持有m.mu
+func (m *Maglev) rebuild() {
+	// 排序让查找表只取决于服务器集合，与Add/Remove的顺序无关
+	hosts := append([]string(nil), m.hosts...)
+	sort.Strings(hosts)
+
+	t := &maglevTable{hosts: hosts}
+	n := len(hosts)
+	if n == 0 {
+		m.table.Store(t)
+		return
+	}
+
+	offsets := make([]uint64, n)
+	skips := make([]uint64, n)
+	for i, host := range hosts {
+		offsets[i] = m.hash([]byte(host)) % m.size
+		skips[i] = m.hash([]byte(host+"#skip"))%(m.size-1) + 1
+	}
+
+	entry := make([]int32, m.size)
+	for i := range entry {
+		entry[i] = -1
+	}
+	next := make([]uint64, n)
+
+	for filled := uint64(0); ; {
+		for i := 0; i < n; i++ {
+			c := (offsets[i] + next[i]*skips[i]) % m.size
+			for entry[c] >= 0 {
+				next[i]++
+				c = (offsets[i] + next[i]*skips[i]) % m.size
+			}
+			entry[c] = int32(i)
+			next[i]++
+			filled++
+			if filled == m.size {
+				t.entry = entry
+				m.table.Store(t)
+				return
+			}
+		}
+	}
+}
+
+// nextPrime 返回不小于n的最小质数
+func nextPrime(n uint64) uint64 {
+	if n <= 2 {
+		return 2
+	}
+	if n%2 == 0 {
+		n++
+	}
+	for ; ; n += 2 {
+		prime := true
+		for d := uint64(3); d*d <= n; d += 2 {
+			if n%d == 0 {
+				prime = false
+				break
+			}
+		}
+		if prime {
+			return n
+		}
+	}
+}
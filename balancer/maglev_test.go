@@ -0,0 +1,39 @@
+package balancer
+
+import "testing"
+
+// movedFraction 返回移除一台服务器后改变归属的key的比例
+func movedFraction(t *testing.T, lb Balancer, keys []string, removed string) float64 {
+	t.Helper()
+	before := owners(t, lb, keys)
+	lb.Remove(removed)
+	after := owners(t, lb, keys)
+
+	moved := 0
+	for _, key := range keys {
+		if before[key] != after[key] {
+			moved++
+		}
+	}
+	return float64(moved) / float64(len(keys))
+}
+
+// TestMaglevDisruption 移除N台服务器中的一台，Maglev只重新分配约1/N的key，而IPHash取模会重新分配大部分key
+func TestMaglevDisruption(t *testing.T) {
+	const n = 10
+	hosts := testHosts(n)
+	keys := testKeys(20000)
+	removed := hosts[3]
+
+	maglev := movedFraction(t, NewMaglev(append([]string(nil), hosts...)), keys, removed)
+	ipHash := movedFraction(t, NewIPHash(append([]string(nil), hosts...)), keys, removed)
+	t.Logf("moved keys: maglev %.3f, ip-hash %.3f", maglev, ipHash)
+
+	// Maglev的查找表在服务器变化时会有少量额外的表项变化，允许比1/N稍多
+	if maglev < 0.5/n || maglev > 1.5/n {
+		t.Errorf("maglev moved %.3f of keys, want about %.3f", maglev, 1.0/n)
+	}
+	if ipHash < 0.5 {
+		t.Errorf("ip-hash moved %.3f of keys, want most of them", ipHash)
+	}
+}
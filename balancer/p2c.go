@@ -0,0 +1,120 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Power of Two Choices 负载均衡算法实现
+// 随机挑选两个服务器，选择其中活跃请求数较少的一个
+package balancer
+
+import (
+	"time"
+)
+
+// init函数在包被导入时自动执行
+// 注册p2c算法到工厂映射表
+func init() {
+	MustRegister(P2CBalancer, func(backends []*Backend, opts *Options) Balancer {
+		return NewP2CWithSeed(hostsOf(backends), opts.seed())
+	})
+}
+
+// P2C Power of Two Choices 负载均衡器结构体
+//
+// 算法原理：
+// 1. 从存活的服务器中随机选出两个不同的服务器
+// 2. 比较两者的活跃请求数（由Inc/Done维护），选择较小的那个
+//
+// 只比较两个服务器就能让最大负载从 O(log n / log log n) 降到 O(log log n)，
+// 而且不需要像最少连接算法那样遍历或排序所有服务器
+//
+// 负载计数的一致性：
+// 健康检查可能在请求还没结束时就把服务器Remove掉，
+// 此时该服务器的计数会保留到所有在途请求Done为止，然后才被清理。
+// 如果服务器在这期间又被Add回来，会继续使用原来的计数，
+// 这样迟到的Done不会把新计数减成负数
+type P2C struct {
+	BaseBalancer
+
+	// loads 记录每个服务器的活跃请求数
+	// 由BaseBalancer的读写锁保护：Balance持有读锁读取，Inc/Done持有写锁修改
+	loads map[string]uint64
+
+	rnd *lockedRand
+}
+
+// NewP2C 创建一个新的p2c负载均衡器
+func NewP2C(hosts []string) Balancer {
+	return NewP2CWithSeed(hosts, time.Now().UnixNano())
+}
+
+// NewP2CWithSeed 使用指定种子创建p2c负载均衡器，便于测试复现
+func NewP2CWithSeed(hosts []string, seed int64) Balancer {
+	return &P2C{
+		BaseBalancer: BaseBalancer{
+			hosts: hosts,
+		},
+		loads: make(map[string]uint64),
+		rnd:   newLockedRand(seed),
+	}
+}
+
+// Remove 从负载均衡池中移除服务器
+// 如果服务器还有在途请求，保留它的计数，等Done把计数减到0时再清理
+func (p *P2C) Remove(host string) {
+	p.BaseBalancer.Remove(host)
+
+	p.Lock()
+	defer p.Unlock()
+	if p.loads[host] == 0 {
+		delete(p.loads, host)
+	}
+}
+
+// Balance 随机选择两个服务器，返回活跃请求数较少的那个
+// 参数 key 在p2c算法中不使用
+func (p *P2C) Balance(_ string) (string, error) {
+	p.RLock()
+	defer p.RUnlock()
+
+	n := len(p.hosts)
+	if n == 0 {
+		return "", NoHostError
+	}
+	if n == 1 {
+		return p.hosts[0], nil
+	}
+
+	// 选出两个不同的下标：j从剩下的n-1个位置中选，跳过i
+	i := p.rnd.Intn(n)
+	j := p.rnd.Intn(n - 1)
+	if j >= i {
+		j++
+	}
+
+	if p.loads[p.hosts[j]] < p.loads[p.hosts[i]] {
+		return p.hosts[j], nil
+	}
+	return p.hosts[i], nil
+}
+
+// Inc 增加服务器的活跃请求数
+// Balance和Inc之间服务器可能已被移除，这里仍然计数，保证与Done配对
+func (p *P2C) Inc(host string) {
+	p.Lock()
+	defer p.Unlock()
+	p.loads[host]++
+}
+
+// Done 减少服务器的活跃请求数
+// 已被移除的服务器在计数归零后清理
+func (p *P2C) Done(host string) {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.loads[host] > 0 {
+		p.loads[host]--
+	}
+	if p.loads[host] == 0 && !p.has(host) {
+		delete(p.loads, host)
+	}
+}
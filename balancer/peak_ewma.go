@@ -0,0 +1,203 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Peak EWMA 负载均衡算法实现
+// 根据后端的响应延迟和活跃请求数选择服务器，与Finagle/Linkerd的peak-ewma相同
+package balancer
+
+import (
+	"math"
+	"time"
+)
+
+// init函数在包被导入时自动执行
+// 注册peak-ewma算法到工厂映射表
+func init() {
+	MustRegister(PeakEWMABalancer, func(backends []*Backend, opts *Options) Balancer {
+		return NewPeakEWMAWith(hostsOf(backends), DefaultDecayTime, opts.seed())
+	})
+}
+
+const (
+	// DefaultDecayTime 是延迟衰减的时间常数
+	// 距离上次观测过去DecayTime后，旧延迟的权重衰减为 1/e
+	DefaultDecayTime = 10 * time.Second
+
+	// peakEWMAPenalty 是失败请求记录的延迟，也是没有观测数据的服务器有在途请求时的惩罚值
+	// 失败的请求往往返回得很快，如果按实际耗时记录，出错的服务器反而会显得很快
+	peakEWMAPenalty = time.Second
+)
+
+// ewmaStat 是一个服务器的延迟统计
+type ewmaStat struct {
+	// cost 是衰减后的延迟，单位纳秒
+	cost float64
+	// stamp 是cost最后一次更新的时间
+	stamp time.Time
+	// pending 是活跃请求数
+	pending uint64
+}
+
+// PeakEWMA Peak EWMA 负载均衡器结构体
+//
+// 算法原理：
+//  1. 每个服务器维护一个指数加权移动平均（EWMA）的延迟cost
+//  2. 观测到的延迟比cost大时，cost直接取观测值（peak），对变慢的服务器立即做出反应
+//  3. 观测到的延迟比cost小时，按距离上次观测的时间Δt衰减：cost = cost*w + rtt*(1-w)，
+//     其中 w = exp(-Δt / decay)，服务器恢复后cost逐渐回落
+//  4. 服务器的负载 = cost * (pending + 1)，同样的延迟下活跃请求越多负载越大
+//  5. 和p2c一样随机挑选两个服务器，选择负载较小的那个
+//
+// 相比只看活跃请求数的least-load和p2c，peak-ewma能避开响应变慢的服务器，
+// 例如正在GC或者所在机器负载过高的服务器
+type PeakEWMA struct {
+	BaseBalancer
+
+	// decay 衰减时间常数
+	decay time.Duration
+
+	// stats 记录每个服务器的延迟统计
+	// 与P2C相同，被移除的服务器的统计保留到在途请求全部Done
+	stats map[string]*ewmaStat
+
+	rnd *lockedRand
+}
+
+// NewPeakEWMA 使用默认的衰减时间创建peak-ewma负载均衡器
+func NewPeakEWMA(hosts []string) Balancer {
+	return NewPeakEWMAWith(hosts, DefaultDecayTime, time.Now().UnixNano())
+}
+
+// NewPeakEWMAWith 使用指定的衰减时间和随机种子创建peak-ewma负载均衡器
+// decay 小于等于0时使用DefaultDecayTime
+func NewPeakEWMAWith(hosts []string, decay time.Duration, seed int64) Balancer {
+	if decay <= 0 {
+		decay = DefaultDecayTime
+	}
+	return &PeakEWMA{
+		BaseBalancer: BaseBalancer{
+			hosts: hosts,
+		},
+		decay: decay,
+		stats: make(map[string]*ewmaStat),
+		rnd:   newLockedRand(seed),
+	}
+}
+
+// Remove 从负载均衡池中移除服务器
+// 没有在途请求的服务器直接丢弃统计，重新加入时从零开始观测
+func (p *PeakEWMA) Remove(host string) {
+	p.BaseBalancer.Remove(host)
+
+	p.Lock()
+	defer p.Unlock()
+	if s, ok := p.stats[host]; ok && s.pending == 0 {
+		delete(p.stats, host)
+	}
+}
+
+// Balance 随机选择两个服务器，返回负载较小的那个
+// 参数 key 在peak-ewma算法中不使用
+func (p *PeakEWMA) Balance(_ string) (string, error) {
+	p.RLock()
+	defer p.RUnlock()
+
+	n := len(p.hosts)
+	if n == 0 {
+		return "", NoHostError
+	}
+	if n == 1 {
+		return p.hosts[0], nil
+	}
+
+	i := p.rnd.Intn(n)
+	j := p.rnd.Intn(n - 1)
+	if j >= i {
+		j++
+	}
+
+	now := time.Now()
+	if p.load(p.hosts[j], now) < p.load(p.hosts[i], now) {
+		return p.hosts[j], nil
+	}
+	return p.hosts[i], nil
+}
+
+// load 计算服务器当前的负载
+// 调用者必须已经持有读锁或写锁
+func (p *PeakEWMA) load(host string, now time.Time) float64 {
+	s, ok := p.stats[host]
+	if !ok {
+		return 0
+	}
+
+	// 读取时按经过的时间把cost向0衰减，长时间没有请求的服务器会重新获得尝试的机会
+	cost := s.cost * math.Exp(-float64(now.Sub(s.stamp))/float64(p.decay))
+	if cost == 0 && s.pending != 0 {
+		// 还没有任何观测数据，但已经有在途请求，避免新服务器一下子收到所有请求
+		return float64(peakEWMAPenalty) + float64(s.pending)
+	}
+	return cost * float64(s.pending+1)
+}
+
+// stat 返回服务器的统计，不存在时创建
+// 调用者必须已经持有写锁
+func (p *PeakEWMA) stat(host string) *ewmaStat {
+	s, ok := p.stats[host]
+	if !ok {
+		s = &ewmaStat{stamp: time.Now()}
+		p.stats[host] = s
+	}
+	return s
+}
+
+// Inc 增加服务器的活跃请求数
+func (p *PeakEWMA) Inc(host string) {
+	p.Lock()
+	defer p.Unlock()
+	p.stat(host).pending++
+}
+
+// Done 减少服务器的活跃请求数
+func (p *PeakEWMA) Done(host string) {
+	p.Lock()
+	defer p.Unlock()
+
+	s, ok := p.stats[host]
+	if !ok {
+		return
+	}
+	if s.pending > 0 {
+		s.pending--
+	}
+	if s.pending == 0 && !p.has(host) {
+		delete(p.stats, host)
+	}
+}
+
+// Report 用请求耗时更新服务器的延迟统计
+// 失败的请求按peakEWMAPenalty记录
+func (p *PeakEWMA) Report(host string, result Result) {
+	rtt := float64(result.Duration)
+	if result.Failed() && rtt < float64(peakEWMAPenalty) {
+		rtt = float64(peakEWMAPenalty)
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	if _, ok := p.stats[host]; !ok && !p.has(host) {
+		// 已经移除并且清理过的服务器，不再记录
+		return
+	}
+	s := p.stat(host)
+	now := time.Now()
+	if rtt > s.cost {
+		s.cost = rtt
+	} else {
+		w := math.Exp(-float64(now.Sub(s.stamp)) / float64(p.decay))
+		s.cost = s.cost*w + rtt*(1-w)
+	}
+	s.stamp = now
+}
@@ -0,0 +1,91 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// 带请求上下文的服务器选择接口
+// Balancer.Balance只能拿到一个字符串key，Picker可以拿到完整的请求信息
+package balancer
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+)
+
+// maxPickAttempts 是适配器为了避开已尝试的服务器，最多调用Balance的次数
+const maxPickAttempts = 8
+
+// PickInfo 是一次选择所需的请求信息
+type PickInfo struct {
+	// Ctx 请求的上下文，带有客户端取消和超时信息
+	// 上下文已经结束时，不应该再选择服务器
+	Ctx context.Context
+
+	// Request 原始的HTTP请求，算法可以读取请求头、路径等信息
+	// 算法不应该修改请求，也不应该读取请求体
+	Request *http.Request
+
+	// Key 代理根据hash_key配置从请求中提取的key，即传给Balance的参数
+	Key string
+
+	// Tried 本次请求已经尝试过的服务器
+	// 重试时应该尽量避开这些服务器，为nil表示是第一次选择
+	Tried map[string]bool
+}
+
+// Picker 是带请求上下文的选择接口
+//
+// 与Balancer的关系：
+// Balancer负责服务器的管理（Add/Remove）和负载追踪（Inc/Done/Report），
+// Picker只负责选择。需要完整请求信息的算法同时实现Balancer和Picker，
+// 代理通过AsPicker拿到Picker，已有的算法（如IPHash）不需要任何修改
+type Picker interface {
+	// Pick 根据请求信息选择一个服务器
+	// 返回值：选中的服务器地址和可能的错误
+	Pick(*PickInfo) (string, error)
+}
+
+// AsPicker 返回负载均衡器对应的Picker
+// 如果负载均衡器自己实现了Picker，直接返回它；否则用适配器包装它的Balance方法
+func AsPicker(b Balancer) Picker {
+	if p, ok := b.(Picker); ok {
+		return p
+	}
+	return &balancerPicker{b: b}
+}
+
+// balancerPicker 把只实现了Balancer的负载均衡器适配为Picker
+type balancerPicker struct {
+	b Balancer
+}
+
+// Pick 调用Balance选择服务器
+//
+//  1. 上下文已经结束时直接返回上下文的错误
+//  2. 选中的服务器已经尝试过时，在key后面加上序号重新调用Balance，
+//     对于哈希类算法这相当于换一个key，对于轮询、随机类算法这就是再选一次
+//  3. 尝试maxPickAttempts次仍然只能选到已尝试过的服务器时，返回第一次选中的服务器，
+//     由调用者决定是否再次使用它
+func (p *balancerPicker) Pick(info *PickInfo) (string, error) {
+	if info.Ctx != nil {
+		if err := info.Ctx.Err(); err != nil {
+			return "", err
+		}
+	}
+
+	first, err := p.b.Balance(info.Key)
+	if err != nil || !info.Tried[first] {
+		return first, err
+	}
+
+	for i := 1; i < maxPickAttempts; i++ {
+		host, err := p.b.Balance(info.Key + "#" + strconv.Itoa(i))
+		if err != nil {
+			return "", err
+		}
+		if !info.Tried[host] {
+			return host, nil
+		}
+	}
+	return first, nil
+}
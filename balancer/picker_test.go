@@ -0,0 +1,111 @@
+package balancer
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// keyedBalancer 按key查表返回服务器，并记录每次Balance收到的key
+type keyedBalancer struct {
+	BaseBalancer
+	owners map[string]string
+	keys   []string
+}
+
+func (b *keyedBalancer) Balance(key string) (string, error) {
+	b.keys = append(b.keys, key)
+	host, ok := b.owners[key]
+	if !ok {
+		return "", NoHostError
+	}
+	return host, nil
+}
+
+// TestPickRekeysAroundTried 选中已尝试的服务器时，在key后面加上序号重新选择
+func TestPickRekeysAroundTried(t *testing.T) {
+	b := &keyedBalancer{owners: map[string]string{
+		"k":   "a",
+		"k#1": "a",
+		"k#2": "b",
+		"k#3": "c",
+	}}
+	p := AsPicker(b)
+
+	host, err := p.Pick(&PickInfo{Ctx: context.Background(), Key: "k", Tried: map[string]bool{"a": true}})
+	if err != nil {
+		t.Fatalf("Pick: %v", err)
+	}
+	if host != "b" {
+		t.Fatalf("Pick = %s, want b", host)
+	}
+	if want := []string{"k", "k#1", "k#2"}; !reflect.DeepEqual(b.keys, want) {
+		t.Fatalf("Balance keys = %v, want %v", b.keys, want)
+	}
+
+	// 第一次选择不需要换key
+	b.keys = nil
+	if host, _ := p.Pick(&PickInfo{Key: "k"}); host != "a" || len(b.keys) != 1 {
+		t.Fatalf("first Pick = %s after %d Balance calls, want a after 1", host, len(b.keys))
+	}
+}
+
+// TestPickRekeysConsistentHash 哈希类算法换key之后可以避开已尝试的服务器
+func TestPickRekeysConsistentHash(t *testing.T) {
+	lb := NewConsistentHash(testHosts(5))
+	p := AsPicker(lb)
+	for _, key := range testKeys(100) {
+		first, err := lb.Balance(key)
+		if err != nil {
+			t.Fatalf("Balance: %v", err)
+		}
+		host, err := p.Pick(&PickInfo{Key: key, Tried: map[string]bool{first: true}})
+		if err != nil {
+			t.Fatalf("Pick: %v", err)
+		}
+		if host == first {
+			t.Fatalf("key %s: Pick returned the tried host %s", key, host)
+		}
+	}
+}
+
+// TestPickContextDone 上下文已经结束时返回上下文的错误，不调用Balance
+func TestPickContextDone(t *testing.T) {
+	b := &keyedBalancer{owners: map[string]string{"k": "a"}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	host, err := AsPicker(b).Pick(&PickInfo{Ctx: ctx, Key: "k"})
+	if err != context.Canceled || host != "" {
+		t.Fatalf("Pick = %q, %v, want context.Canceled", host, err)
+	}
+	if len(b.keys) != 0 {
+		t.Fatalf("Balance called %d times after the context was done", len(b.keys))
+	}
+}
+
+// TestPickReturnsFirstTried 所有尝试都只能选到已尝试的服务器时，返回第一次选中的服务器
+func TestPickReturnsFirstTried(t *testing.T) {
+	lb := NewRoundRobin([]string{"a"})
+	host, err := AsPicker(lb).Pick(&PickInfo{Key: "k", Tried: map[string]bool{"a": true}})
+	if err != nil {
+		t.Fatalf("Pick: %v", err)
+	}
+	if host != "a" {
+		t.Fatalf("Pick = %s, want a", host)
+	}
+
+	// 换key之后的每次选择都落在已尝试的服务器上，最多调用maxPickAttempts次
+	b := &keyedBalancer{owners: map[string]string{"k": "a"}}
+	for i := 1; i < maxPickAttempts; i++ {
+		b.owners["k#"+strconv.Itoa(i)] = "b"
+	}
+	host, err = AsPicker(b).Pick(&PickInfo{Key: "k", Tried: map[string]bool{"a": true, "b": true}})
+	if err != nil || host != "a" {
+		t.Fatalf("Pick = %s, %v, want a", host, err)
+	}
+	if len(b.keys) != maxPickAttempts {
+		t.Fatalf("Balance called %d times, want %d", len(b.keys), maxPickAttempts)
+	}
+}
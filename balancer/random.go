@@ -0,0 +1,89 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// 随机负载均衡算法实现
+// 从服务器列表中随机选择一个服务器
+package balancer
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// init函数在包被导入时自动执行
+// 注册随机算法到工厂映射表
+func init() {
+	MustRegister(RandomBalancer, func(backends []*Backend, opts *Options) Balancer {
+		return NewRandomWithSeed(hostsOf(backends), opts.seed())
+	})
+}
+
+// lockedRand 是并发安全的随机数生成器
+// rand.New 创建的 *rand.Rand 不是并发安全的，这里用互斥锁保护
+// 使用独立的随机源而不是全局随机源，是为了可以指定种子，让测试结果可复现
+type lockedRand struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// newLockedRand 使用指定种子创建随机数生成器
+func newLockedRand(seed int64) *lockedRand {
+	return &lockedRand{rnd: rand.New(rand.NewSource(seed))}
+}
+
+// Intn 返回 [0, n) 范围内的随机整数，n 必须大于0
+func (r *lockedRand) Intn(n int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rnd.Intn(n)
+}
+
+// Float64 返回 [0, 1) 范围内的随机浮点数
+func (r *lockedRand) Float64() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rnd.Float64()
+}
+
+// Random 随机负载均衡器结构体
+//
+// 算法原理：每次请求从hosts中等概率选择一个服务器
+// 请求量足够大时，各服务器收到的请求数趋于相同
+type Random struct {
+	BaseBalancer
+
+	// rnd 是随机数生成器，与BaseBalancer的读写锁相互独立
+	// Balance只持有读锁，所以随机源需要自己的锁
+	rnd *lockedRand
+}
+
+// NewRandom 创建一个新的随机负载均衡器
+// 使用当前时间作为随机种子
+func NewRandom(hosts []string) Balancer {
+	return NewRandomWithSeed(hosts, time.Now().UnixNano())
+}
+
+// NewRandomWithSeed 使用指定种子创建随机负载均衡器
+// 相同的种子和相同的调用顺序会得到相同的选择结果，便于测试
+func NewRandomWithSeed(hosts []string, seed int64) Balancer {
+	return &Random{
+		BaseBalancer: BaseBalancer{
+			hosts: hosts,
+		},
+		rnd: newLockedRand(seed),
+	}
+}
+
+// Balance 随机选择一个服务器
+// 参数 key 在随机算法中不使用
+func (r *Random) Balance(_ string) (string, error) {
+	r.RLock()
+	defer r.RUnlock()
+
+	if len(r.hosts) == 0 {
+		return "", NoHostError
+	}
+	return r.hosts[r.rnd.Intn(len(r.hosts))], nil
+}
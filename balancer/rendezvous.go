@@ -0,0 +1,168 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Rendezvous（最高随机权重，HRW）哈希负载均衡算法实现
+// 对每个服务器计算key的得分，选择得分最高的服务器
+package balancer
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// init函数在包被导入时自动执行
+// 注册rendezvous算法到工厂映射表
+func init() {
+	MustRegister(RendezvousBalancer, func(backends []*Backend, opts *Options) Balancer {
+		return newRendezvous(backends, opts.Hash, newSlowStart(opts.SlowStartWindow, opts.SlowStartAggression))
+	})
+}
+
+// rendezvousNode 是参与计算得分的一个服务器
+type rendezvousNode struct {
+	host string
+	// hash 是服务器地址的哈希值，提前算好避免每次请求重复计算
+	hash   uint64
+	weight float64
+}
+
+// Rendezvous 最高随机权重哈希负载均衡器结构体
+//
+// 算法原理（加权HRW）：
+// 1. 对每个服务器，把key和服务器的哈希混合，映射为 (0, 1) 之间的均匀随机数u
+// 2. 服务器的得分 score = -weight / ln(u)，开启慢启动时weight还要乘以慢启动系数
+// 3. 选择得分最高的服务器
+//
+// 特点：
+// 1. 不需要哈希环或查找表，只保存服务器列表，Add/Remove是O(n)的切片操作
+// 2. 每个服务器被选中的概率与权重成正比
+// 3. 移除一个服务器时，只有原本选中它的key会改选得分第二高的服务器，其余key不变
+// 4. Balance需要计算所有服务器的得分，时间复杂度O(n)，适合服务器数量不多的场景
+type Rendezvous struct {
+	sync.RWMutex
+
+	nodes []rendezvousNode
+
+	// weights 记录每个服务器配置的权重，服务器被移除后再Add回来时从这里恢复
+	weights map[string]int
+
+	hash HashFunc
+
+	// slowStart 为nil表示没有开启慢启动
+	slowStart *slowStart
+}
+
+// NewRendezvous 使用默认的哈希函数创建rendezvous负载均衡器，所有服务器权重为1
+func NewRendezvous(hosts []string) Balancer {
+	backends := make([]*Backend, 0, len(hosts))
+	for _, host := range hosts {
+		backends = append(backends, &Backend{Host: host, Weight: 1})
+	}
+	return NewRendezvousWith(backends, nil)
+}
+
+// NewRendezvousWith 使用指定的哈希函数创建带权重的rendezvous负载均衡器
+// hash 为nil时使用FNV64aHash
+func NewRendezvousWith(backends []*Backend, hash HashFunc) Balancer {
+	return newRendezvous(backends, hash, nil)
+}
+
+// newRendezvous 创建rendezvous负载均衡器，slowStart为nil表示不开启慢启动
+func newRendezvous(backends []*Backend, hash HashFunc, slowStart *slowStart) *Rendezvous {
+	if hash == nil {
+		hash = FNV64aHash
+	}
+	r := &Rendezvous{
+		weights: make(map[string]int),
+		hash:    hash,
+	}
+	for _, b := range backends {
+		weight := b.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		r.weights[b.Host] = weight
+		r.Add(b.Host)
+	}
+	// 初始服务器不需要慢启动，所以在添加完之后才开启
+	r.slowStart = slowStart
+	return r
+}
+
+// Add 添加服务器，开启慢启动时服务器从较小的有效权重开始
+// 构造时没有出现过的服务器权重为1
+func (r *Rendezvous) Add(host string) {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, n := range r.nodes {
+		if n.host == host {
+			return
+		}
+	}
+
+	weight, ok := r.weights[host]
+	if !ok {
+		weight = 1
+	}
+	r.nodes = append(r.nodes, rendezvousNode{
+		host:   host,
+		hash:   r.hash([]byte(host)),
+		weight: float64(weight),
+	})
+	r.slowStart.add(host, time.Now())
+}
+
+// Remove 移除服务器，保留它的权重
+func (r *Rendezvous) Remove(host string) {
+	r.Lock()
+	defer r.Unlock()
+
+	for i, n := range r.nodes {
+		if n.host == host {
+			r.nodes = append(r.nodes[:i], r.nodes[i+1:]...)
+			r.slowStart.remove(host)
+			return
+		}
+	}
+}
+
+// Balance 返回key得分最高的服务器
+func (r *Rendezvous) Balance(key string) (string, error) {
+	r.RLock()
+	defer r.RUnlock()
+
+	if len(r.nodes) == 0 {
+		return "", NoHostError
+	}
+
+	keyHash := r.hash([]byte(key))
+	now := time.Now()
+	best, bestScore := "", math.Inf(-1)
+	for _, n := range r.nodes {
+		weight := n.weight * r.slowStart.factor(n.host, now)
+		score := -weight / math.Log(unitFloat(mix64(keyHash^n.hash)))
+		// 得分相同时取地址较小的，保证结果与服务器的加入顺序无关
+		if score > bestScore || (score == bestScore && n.host < best) {
+			best, bestScore = n.host, score
+		}
+	}
+	return best, nil
+}
+
+// unitFloat 把64位哈希值映射为开区间 (0, 1) 内的浮点数
+// 取高53位（float64尾数的精度），加0.5避免得到0，ln(0)是负无穷
+func unitFloat(h uint64) float64 {
+	return (float64(h>>11) + 0.5) / (1 << 53)
+}
+
+// Inc rendezvous不需要追踪连接数
+func (r *Rendezvous) Inc(_ string) {}
+
+// Done rendezvous不需要追踪连接数
+func (r *Rendezvous) Done(_ string) {}
+
+// Report rendezvous不需要请求结果
+func (r *Rendezvous) Report(_ string, _ Result) {}
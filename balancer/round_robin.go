@@ -0,0 +1,61 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// 轮询负载均衡算法实现
+// 按照服务器列表顺序依次分配请求
+package balancer
+
+import (
+	"sync/atomic"
+)
+
+// init函数在包被导入时自动执行
+// 注册轮询算法到工厂映射表
+func init() {
+	MustRegister(R2Balancer, hostsOnly(NewRoundRobin))
+}
+
+// RoundRobin 轮询负载均衡器结构体
+//
+// 算法原理：
+// 维护一个单调递增的计数器，每次请求计数器加一，
+// 用计数器对服务器数量取模得到服务器索引
+//
+// 并发安全：
+// 1. 计数器使用原子操作，多个goroutine同时调用Balance不会分到同一个序号
+// 2. hosts切片由BaseBalancer的读写锁保护，健康检查并发调用Add/Remove是安全的
+// 3. 服务器数量变化后，取模的结果会重新分布，但不会越界
+type RoundRobin struct {
+	BaseBalancer
+
+	// i 是请求计数器，只增不减
+	// uint64溢出后从0重新开始，不影响轮询的正确性
+	i atomic.Uint64
+}
+
+// NewRoundRobin 创建一个新的轮询负载均衡器
+// 通过hostsOnly适配为Factory函数签名
+func NewRoundRobin(hosts []string) Balancer {
+	return &RoundRobin{
+		BaseBalancer: BaseBalancer{
+			hosts: hosts,
+		},
+	}
+}
+
+// Balance 按顺序选择下一个服务器
+// 参数 key 在轮询算法中不使用
+func (r *RoundRobin) Balance(_ string) (string, error) {
+	r.RLock()
+	defer r.RUnlock()
+
+	if len(r.hosts) == 0 {
+		return "", NoHostError
+	}
+
+	// Add返回的是加一之后的值，减一得到本次请求的序号
+	// 这样第一个请求会落在hosts[0]上
+	n := r.i.Add(1) - 1
+	return r.hosts[n%uint64(len(r.hosts))], nil
+}
@@ -0,0 +1,116 @@
+package balancer
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestRoundRobinEvenDistribution(t *testing.T) {
+	const rounds = 1000
+	hosts := []string{"127.0.0.1:8001", "127.0.0.1:8002", "127.0.0.1:8003", "127.0.0.1:8004", "127.0.0.1:8005"}
+	lb := NewRoundRobin(append([]string(nil), hosts...))
+
+	counts := make(map[string]int)
+	for i := 0; i < len(hosts)*rounds; i++ {
+		host, err := lb.Balance("")
+		if err != nil {
+			t.Fatalf("Balance: %v", err)
+		}
+		counts[host]++
+	}
+
+	if len(counts) != len(hosts) {
+		t.Fatalf("got picks for %d hosts, want %d: %v", len(counts), len(hosts), counts)
+	}
+	for _, host := range hosts {
+		if counts[host] != rounds {
+			t.Errorf("host %s got %d picks, want %d", host, counts[host], rounds)
+		}
+	}
+}
+
+func TestRoundRobinNoHost(t *testing.T) {
+	lb := NewRoundRobin(nil)
+	if _, err := lb.Balance(""); err != NoHostError {
+		t.Fatalf("Balance on empty balancer returned %v, want NoHostError", err)
+	}
+}
+
+// TestRoundRobinConcurrentAddRemove 在Balance的同时并发Add/Remove，运行时应加上 -race
+func TestRoundRobinConcurrentAddRemove(t *testing.T) {
+	stable := []string{"127.0.0.1:8001", "127.0.0.1:8002", "127.0.0.1:8003"}
+	removed := make(map[string]bool)
+	hosts := append([]string(nil), stable...)
+	for i := 0; i < 3; i++ {
+		host := fmt.Sprintf("127.0.0.1:90%02d", i)
+		hosts = append(hosts, host)
+		removed[host] = true
+	}
+	lb := NewRoundRobin(hosts)
+	for host := range removed {
+		lb.Remove(host)
+	}
+
+	// flapping 在测试期间不断被加入和移除
+	flapping := []string{"127.0.0.1:9101", "127.0.0.1:9102"}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for _, host := range flapping {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					lb.Remove(host)
+					return
+				default:
+					lb.Add(host)
+					lb.Remove(host)
+				}
+			}
+		}(host)
+	}
+
+	errs := make(chan error, 8)
+	var pickers sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		pickers.Add(1)
+		go func() {
+			defer pickers.Done()
+			for i := 0; i < 10000; i++ {
+				host, err := lb.Balance("")
+				if err != nil {
+					errs <- fmt.Errorf("Balance: %v", err)
+					return
+				}
+				if removed[host] {
+					errs <- fmt.Errorf("Balance returned removed host %s", host)
+					return
+				}
+			}
+		}()
+	}
+	pickers.Wait()
+	close(stop)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// 所有flapping服务器都已移除，之后不应该再被选中
+	for i := 0; i < 100; i++ {
+		host, err := lb.Balance("")
+		if err != nil {
+			t.Fatalf("Balance: %v", err)
+		}
+		for _, f := range flapping {
+			if host == f {
+				t.Fatalf("Balance returned removed host %s", host)
+			}
+		}
+	}
+}
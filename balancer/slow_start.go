@@ -0,0 +1,96 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// 慢启动实现
+// 刚加入的服务器的有效权重在一段时间内从一个较小的比例逐渐增加到配置的权重
+package balancer
+
+import (
+	"math"
+	"time"
+)
+
+// DefaultSlowStartMinWeight 是慢启动开始时有效权重占配置权重的比例
+// 不从0开始，是为了让服务器在慢启动一开始就能收到少量请求完成预热
+const DefaultSlowStartMinWeight = 0.1
+
+// slowStart 记录服务器加入的时间，计算慢启动期间的权重系数
+//
+// 系数的计算方式与Envoy的slow start相同：
+// factor = max(minWeight, (elapsed / window) ^ (1 / aggression))
+//   - aggression 为1时线性增长
+//   - aggression 大于1时前期增长快、后期增长慢
+//   - aggression 小于1时前期增长慢、后期增长快
+//
+// 不是并发安全的，由使用它的负载均衡器加锁保护；nil表示没有开启慢启动
+type slowStart struct {
+	window     time.Duration
+	aggression float64
+	minWeight  float64
+
+	// startedAt 记录处于慢启动期间的服务器的加入时间
+	startedAt map[string]time.Time
+}
+
+// newSlowStart 创建慢启动，window小于等于0时返回nil，表示不开启慢启动
+// aggression 小于等于0时按1（线性）处理
+func newSlowStart(window time.Duration, aggression float64) *slowStart {
+	if window <= 0 {
+		return nil
+	}
+	if aggression <= 0 {
+		aggression = 1
+	}
+	return &slowStart{
+		window:     window,
+		aggression: aggression,
+		minWeight:  DefaultSlowStartMinWeight,
+		startedAt:  make(map[string]time.Time),
+	}
+}
+
+// add 记录服务器开始慢启动
+// 只有通过Add加入的服务器（健康检查恢复、动态扩容）需要慢启动，
+// 创建负载均衡器时的初始服务器直接使用完整权重
+// 调用者必须持有写锁
+func (s *slowStart) add(host string, now time.Time) {
+	if s == nil {
+		return
+	}
+	s.startedAt[host] = now
+
+	// 顺便清理已经结束慢启动的服务器
+	for h, t := range s.startedAt {
+		if now.Sub(t) >= s.window {
+			delete(s.startedAt, h)
+		}
+	}
+}
+
+// remove 服务器被移除后，下次加入时重新开始慢启动
+// 调用者必须持有写锁
+func (s *slowStart) remove(host string) {
+	if s == nil {
+		return
+	}
+	delete(s.startedAt, host)
+}
+
+// factor 返回服务器当前的权重系数，范围是 [minWeight, 1]
+// 调用者必须持有读锁或写锁
+func (s *slowStart) factor(host string, now time.Time) float64 {
+	if s == nil {
+		return 1
+	}
+	t, ok := s.startedAt[host]
+	if !ok {
+		return 1
+	}
+	elapsed := now.Sub(t)
+	if elapsed >= s.window {
+		return 1
+	}
+	f := math.Pow(float64(elapsed)/float64(s.window), 1/s.aggression)
+	return math.Max(s.minWeight, f)
+}
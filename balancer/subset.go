@@ -0,0 +1,236 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// 确定性子集实现
+// 后端服务器很多时，每个负载均衡器实例只连接其中一个稳定的子集，而不是连接所有服务器
+package balancer
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// Subset 确定性子集负载均衡器
+// 在Build时根据Options.InstanceCount自动包装在具体算法的外层，
+// 内层负载均衡器只包含本实例子集中的存活服务器
+//
+// 子集的计算方式与Google SRE书中的deterministic subsetting相同：
+//  1. 把配置的所有服务器排序，按子集大小划分为 subsetCount = 服务器数 / 子集大小 个子集
+//  2. 每 subsetCount 个实例为一轮，同一轮的实例用轮次作为随机种子打乱服务器顺序，
+//     因此同一轮的实例分到互不相交的子集，每一轮的每个服务器最多被一个实例使用
+//  3. 实例使用第 实例ID % subsetCount 个子集
+//
+// 不同轮次的打乱顺序不同，服务器数不能被子集大小整除时，多出的服务器在不同轮次中轮流被使用
+// 子集只根据配置的服务器计算，服务器被健康检查移除时子集不变，只是从内层负载均衡器中移除，
+// 恢复后再加回来；否则任何一个服务器状态抖动都会让所有实例的子集被重新打乱
+// 只有Add了一个从未见过的服务器时才重新计算子集
+//
+// 子集中存活服务器的比例低于subsetMinHealthy时，按本实例打乱后的顺序从子集之外借用存活的服务器，
+// 补足到子集大小，避免子集整体不可用时其他服务器明明可用请求却全部失败；子集中的服务器恢复后借用的服务器被归还
+type Subset struct {
+	sync.Mutex
+
+	id    int
+	count int
+	size  int
+
+	// hosts 是配置的所有服务器，alive 是其中存活的服务器
+	hosts map[string]bool
+	alive map[string]bool
+	// subset 是本实例的子集，ranking 是本实例打乱后的服务器顺序，以子集开头，借用服务器时按这个顺序
+	// active 是已经加入内层负载均衡器的服务器
+	subset  map[string]bool
+	ranking []string
+	active  map[string]bool
+
+	lb     Balancer
+	picker Picker
+}
+
+// subsetFactory 把工厂函数包装为创建确定性子集负载均衡器的工厂函数
+func subsetFactory(factory Factory) Factory {
+	return func(backends []*Backend, opts *Options) Balancer {
+		return newSubset(factory, backends, opts)
+	}
+}
+
+// newSubset 创建确定性子集负载均衡器
+// 内层负载均衡器使用全部服务器创建，以保留每个服务器的权重，然后移除不在子集中的服务器
+func newSubset(factory Factory, backends []*Backend, opts *Options) *Subset {
+	lb := factory(backends, opts)
+	s := &Subset{
+		id:     opts.InstanceID,
+		count:  opts.InstanceCount,
+		size:   opts.SubsetSize,
+		hosts:  make(map[string]bool),
+		alive:  make(map[string]bool),
+		active: make(map[string]bool),
+		lb:     lb,
+		picker: AsPicker(lb),
+	}
+	for _, b := range backends {
+		s.hosts[b.Host] = true
+		s.alive[b.Host] = true
+		s.active[b.Host] = true
+	}
+	s.compute()
+	s.update()
+	return s
+}
+
+// subsetSize 返回子集的大小
+// 没有配置子集大小时，让所有实例合起来正好覆盖每个服务器一次
+func (s *Subset) subsetSize(n int) int {
+	if s.size > 0 {
+		return s.size
+	}
+	if s.count <= 0 {
+		return n
+	}
+	return (n + s.count - 1) / s.count
+}
+
+// compute 计算本实例在配置的所有服务器中的子集，以及借用服务器的顺序
+// 调用者必须持有锁
+func (s *Subset) compute() {
+	hosts := make([]string, 0, len(s.hosts))
+	for host := range s.hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	s.subset = make(map[string]bool)
+	size := s.subsetSize(len(hosts))
+	if size >= len(hosts) {
+		for _, host := range hosts {
+			s.subset[host] = true
+		}
+		s.ranking = hosts
+		return
+	}
+
+	subsetCount := len(hosts) / size
+	round := s.id / subsetCount
+	rnd := rand.New(rand.NewSource(int64(round)))
+	rnd.Shuffle(len(hosts), func(i, j int) {
+		hosts[i], hosts[j] = hosts[j], hosts[i]
+	})
+
+	// 从子集的起点开始循环一圈，子集之后的服务器就是借用的顺序
+	start := (s.id % subsetCount) * size
+	s.ranking = append(hosts[start:], hosts[:start]...)
+	for _, host := range s.ranking[:size] {
+		s.subset[host] = true
+	}
+}
+
+// subsetMinHealthy 子集中存活服务器的比例低于它时，从子集之外借用服务器
+const subsetMinHealthy = 0.5
+
+// targets 返回应该在内层负载均衡器中的服务器：子集中存活的服务器，
+// 存活比例低于subsetMinHealthy时再加上借用的服务器
+// 调用者必须持有锁
+func (s *Subset) targets() map[string]bool {
+	targets := make(map[string]bool, len(s.subset))
+	for host := range s.subset {
+		if s.alive[host] {
+			targets[host] = true
+		}
+	}
+	if float64(len(targets)) >= float64(len(s.subset))*subsetMinHealthy {
+		return targets
+	}
+	for _, host := range s.ranking[len(s.subset):] {
+		if len(targets) >= len(s.subset) {
+			break
+		}
+		if s.alive[host] {
+			targets[host] = true
+		}
+	}
+	return targets
+}
+
+// update 把targets的变化同步到内层负载均衡器
+// 调用者必须持有锁
+func (s *Subset) update() {
+	targets := s.targets()
+	for host := range s.active {
+		if !targets[host] {
+			s.lb.Remove(host)
+			delete(s.active, host)
+		}
+	}
+	for host := range targets {
+		if !s.active[host] {
+			s.lb.Add(host)
+			s.active[host] = true
+		}
+	}
+}
+
+// Add 服务器加入，新的服务器会让子集重新计算
+func (s *Subset) Add(host string) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.alive[host] {
+		return
+	}
+	s.alive[host] = true
+	if !s.hosts[host] {
+		s.hosts[host] = true
+		s.compute()
+	}
+	s.update()
+}
+
+// Remove 服务器移除，子集不变，服务器在子集中时从内层负载均衡器中移除
+// Remove来自健康检查等状态变化，服务器仍然是配置的服务器，所以不从hosts中删除
+func (s *Subset) Remove(host string) {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.alive[host] {
+		return
+	}
+	delete(s.alive, host)
+	s.update()
+}
+
+// Hosts 返回本实例的子集，包括其中不可用的服务器，按地址排序
+func (s *Subset) Hosts() []string {
+	s.Lock()
+	defer s.Unlock()
+
+	hosts := make([]string, 0, len(s.subset))
+	for host := range s.subset {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// 以下方法直接转发给内层负载均衡器
+
+func (s *Subset) Pick(info *PickInfo) (string, error) {
+	return s.picker.Pick(info)
+}
+
+func (s *Subset) Balance(key string) (string, error) {
+	return s.lb.Balance(key)
+}
+
+func (s *Subset) Inc(host string) {
+	s.lb.Inc(host)
+}
+
+func (s *Subset) Done(host string) {
+	s.lb.Done(host)
+}
+
+func (s *Subset) Report(host string, result Result) {
+	s.lb.Report(host, result)
+}
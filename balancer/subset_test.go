@@ -0,0 +1,119 @@
+package balancer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func newTestSubset(t *testing.T, hosts []string, id, count, size int) *Subset {
+	t.Helper()
+	backends := make([]*Backend, len(hosts))
+	for i, host := range hosts {
+		backends[i] = &Backend{Host: host, Weight: 1}
+	}
+	return newSubset(func(backends []*Backend, _ *Options) Balancer {
+		return NewRoundRobin(hostsOf(backends))
+	}, backends, &Options{InstanceID: id, InstanceCount: count, SubsetSize: size})
+}
+
+// TestSubsetStableOnRemove 服务器被移除再恢复时，所有实例的子集都不变
+func TestSubsetStableOnRemove(t *testing.T) {
+	const count = 10
+	hosts := testHosts(20)
+
+	subsets := make([]*Subset, count)
+	before := make([][]string, count)
+	for id := range subsets {
+		subsets[id] = newTestSubset(t, hosts, id, count, 4)
+		before[id] = subsets[id].Hosts()
+	}
+
+	removed := hosts[7]
+	for id, s := range subsets {
+		s.Remove(removed)
+		if got := s.Hosts(); !reflect.DeepEqual(got, before[id]) {
+			t.Errorf("instance %d: subset changed from %v to %v after removing %s", id, before[id], got, removed)
+		}
+		for i := 0; i < 20; i++ {
+			host, err := s.Balance("")
+			if err != nil {
+				t.Fatalf("instance %d: Balance: %v", id, err)
+			}
+			if host == removed {
+				t.Fatalf("instance %d: Balance returned removed host %s", id, host)
+			}
+		}
+	}
+
+	for id, s := range subsets {
+		s.Add(removed)
+		if got := s.Hosts(); !reflect.DeepEqual(got, before[id]) {
+			t.Errorf("instance %d: subset changed from %v to %v after adding back %s", id, before[id], got, removed)
+		}
+		picked := make(map[string]bool)
+		for i := 0; i < 20; i++ {
+			host, _ := s.Balance("")
+			picked[host] = true
+		}
+		if len(picked) != len(before[id]) {
+			t.Errorf("instance %d: picked %d hosts, want the whole subset %v", id, len(picked), before[id])
+		}
+	}
+}
+
+// TestSubsetBorrowsWhenSubsetDown 子集中的服务器全部不可用时，从子集之外借用存活的服务器，
+// 子集恢复后归还借用的服务器
+func TestSubsetBorrowsWhenSubsetDown(t *testing.T) {
+	hosts := testHosts(20)
+	s := newTestSubset(t, hosts, 3, 10, 4)
+	subset := s.Hosts()
+	inSubset := make(map[string]bool)
+	for _, host := range subset {
+		inSubset[host] = true
+	}
+
+	// 只剩一半时子集仍然够用，不借用
+	for _, host := range subset[:2] {
+		s.Remove(host)
+	}
+	for i := 0; i < 20; i++ {
+		host, err := s.Balance("")
+		if err != nil {
+			t.Fatalf("Balance: %v", err)
+		}
+		if !inSubset[host] {
+			t.Fatalf("Balance returned %s outside the subset while half of it is healthy", host)
+		}
+	}
+
+	for _, host := range subset[2:] {
+		s.Remove(host)
+	}
+	borrowed := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		host, err := s.Balance("")
+		if err != nil {
+			t.Fatalf("Balance with the whole subset down: %v", err)
+		}
+		if inSubset[host] {
+			t.Fatalf("Balance returned unhealthy subset member %s", host)
+		}
+		borrowed[host] = true
+	}
+	if len(borrowed) != len(subset) {
+		t.Errorf("borrowed %d hosts, want %d to keep the subset size", len(borrowed), len(subset))
+	}
+	if got := s.Hosts(); !reflect.DeepEqual(got, subset) {
+		t.Errorf("subset changed from %v to %v", subset, got)
+	}
+
+	for _, host := range subset {
+		s.Add(host)
+	}
+	for i := 0; i < 20; i++ {
+		host, _ := s.Balance("")
+		if !inSubset[host] {
+			t.Fatalf("Balance returned borrowed host %s after the subset recovered", host)
+		}
+	}
+}
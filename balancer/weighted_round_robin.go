@@ -0,0 +1,141 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// 平滑加权轮询负载均衡算法实现
+// 按照服务器权重分配请求，与nginx的upstream默认算法相同
+package balancer
+
+import (
+	"sync"
+	"time"
+)
+
+// init函数在包被导入时自动执行
+// 注册平滑加权轮询算法到工厂映射表
+func init() {
+	MustRegister(WeightedRoundRobinBalancer, NewWeightedRoundRobin)
+}
+
+// weightedPeer 是加权轮询中的一个服务器
+type weightedPeer struct {
+	host string
+	// weight 是配置的权重
+	weight int
+	// current 是当前权重，每轮选择时都会变化
+	// 慢启动期间有效权重不是整数，所以使用float64
+	current float64
+}
+
+// WeightedRoundRobin 平滑加权轮询负载均衡器结构体
+//
+// 算法原理（nginx smooth weighted round-robin）：
+// 1. 每次选择时，所有服务器的current加上自己的weight
+// 2. 选出current最大的服务器
+// 3. 被选中的服务器的current减去所有服务器的weight之和
+//
+// 例如权重为 {a:5, b:1, c:1} 时，一轮7次的选择顺序是 a a b a c a a，
+// 而不是普通加权轮询的 a a a a a b c，权重大的服务器不会连续收到大量请求
+//
+// 开启慢启动时，第1步中加上的是有效权重：weight乘以慢启动系数
+type WeightedRoundRobin struct {
+	// Balance会修改current，所以使用互斥锁而不是读写锁
+	sync.Mutex
+
+	// peers 是存活的服务器
+	peers []*weightedPeer
+
+	// weights 记录每个服务器配置的权重
+	// 服务器被移除后再Add回来时，从这里恢复权重
+	weights map[string]int
+
+	// slowStart 为nil表示没有开启慢启动
+	slowStart *slowStart
+}
+
+// NewWeightedRoundRobin 创建一个新的平滑加权轮询负载均衡器
+// 这是工厂函数，实现了Factory函数签名
+func NewWeightedRoundRobin(backends []*Backend, opts *Options) Balancer {
+	w := &WeightedRoundRobin{
+		weights: make(map[string]int),
+	}
+	for _, b := range backends {
+		weight := b.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		w.weights[b.Host] = weight
+		w.Add(b.Host)
+	}
+	// 初始服务器不需要慢启动，所以在添加完之后才开启
+	w.slowStart = newSlowStart(opts.SlowStartWindow, opts.SlowStartAggression)
+	return w
+}
+
+// Add 添加服务器，开启慢启动时服务器从较小的有效权重开始
+// 构造时没有出现过的服务器权重为1
+func (w *WeightedRoundRobin) Add(host string) {
+	w.Lock()
+	defer w.Unlock()
+
+	for _, p := range w.peers {
+		if p.host == host {
+			return
+		}
+	}
+
+	weight, ok := w.weights[host]
+	if !ok {
+		weight = 1
+	}
+	w.peers = append(w.peers, &weightedPeer{host: host, weight: weight})
+	w.slowStart.add(host, time.Now())
+}
+
+// Remove 移除服务器，保留它的权重
+func (w *WeightedRoundRobin) Remove(host string) {
+	w.Lock()
+	defer w.Unlock()
+
+	for i, p := range w.peers {
+		if p.host == host {
+			w.peers = append(w.peers[:i], w.peers[i+1:]...)
+			w.slowStart.remove(host)
+			return
+		}
+	}
+}
+
+// Balance 按平滑加权轮询选择服务器
+// 参数 key 在加权轮询算法中不使用
+func (w *WeightedRoundRobin) Balance(_ string) (string, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	if len(w.peers) == 0 {
+		return "", NoHostError
+	}
+
+	var best *weightedPeer
+	total := 0.0
+	now := time.Now()
+	for _, p := range w.peers {
+		weight := float64(p.weight) * w.slowStart.factor(p.host, now)
+		p.current += weight
+		total += weight
+		if best == nil || p.current > best.current {
+			best = p
+		}
+	}
+	best.current -= total
+	return best.host, nil
+}
+
+// Inc 加权轮询不需要追踪连接数
+func (w *WeightedRoundRobin) Inc(_ string) {}
+
+// Done 加权轮询不需要追踪连接数
+func (w *WeightedRoundRobin) Done(_ string) {}
+
+// Report 加权轮询不需要请求结果
+func (w *WeightedRoundRobin) Report(_ string, _ Result) {}
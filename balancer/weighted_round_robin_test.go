@@ -0,0 +1,49 @@
+package balancer
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestWeightedRoundRobinSmoothOrder 权重为 {a:5, b:1, c:1} 时，每轮的选择顺序是 a a b a c a a
+func TestWeightedRoundRobinSmoothOrder(t *testing.T) {
+	lb := NewWeightedRoundRobin([]*Backend{
+		{Host: "a", Weight: 5},
+		{Host: "b", Weight: 1},
+		{Host: "c", Weight: 1},
+	}, &Options{})
+
+	const want = "a a b a c a a"
+	for round := 0; round < 3; round++ {
+		picks := make([]string, 7)
+		for i := range picks {
+			host, err := lb.Balance("")
+			if err != nil {
+				t.Fatalf("Balance: %v", err)
+			}
+			picks[i] = host
+		}
+		if got := strings.Join(picks, " "); got != want {
+			t.Fatalf("round %d: order = %s, want %s", round, got, want)
+		}
+	}
+}
+
+// TestWeightedRoundRobinRemove 移除服务器后按剩余服务器的权重分配
+func TestWeightedRoundRobinRemove(t *testing.T) {
+	lb := NewWeightedRoundRobin([]*Backend{
+		{Host: "a", Weight: 3},
+		{Host: "b", Weight: 1},
+		{Host: "c", Weight: 2},
+	}, &Options{})
+	lb.Remove("c")
+
+	counts := make(map[string]int)
+	for i := 0; i < 400; i++ {
+		host, _ := lb.Balance("")
+		counts[host]++
+	}
+	if counts["a"] != 300 || counts["b"] != 100 || counts["c"] != 0 {
+		t.Fatalf("counts = %v, want a:300 b:100", counts)
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// 区域感知路由实现
+// 优先把请求发给与负载均衡器在同一区域（zone）的服务器，本区域容量不足时按比例溢出到其他区域
+package balancer
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// 后端服务器的常用标签
+const (
+	// ZoneLabel 服务器所在的可用区，如 "us-east-1a"
+	ZoneLabel = "zone"
+
+	// RegionLabel 服务器所在的地域，如 "us-east-1"
+	RegionLabel = "region"
+)
+
+// DefaultOverprovisioningFactor 是默认的超额配置系数，与Envoy的默认值相同
+// 本区域存活容量的比例不低于 1/1.4 ≈ 71% 时，本区域承担全部流量
+const DefaultOverprovisioningFactor = 1.4
+
+// zonePool 是同一区域的服务器，使用独立的负载均衡器
+type zonePool struct {
+	zone   string
+	lb     Balancer
+	picker Picker
+
+	// weights 记录区域中每个服务器的权重，用于计算区域的容量
+	weights map[string]int
+	// alive 记录区域中当前在负载均衡池里的服务器
+	alive map[string]bool
+
+	// total 和 healthy 分别是区域中所有服务器和存活服务器的权重之和
+	total   int
+	healthy int
+}
+
+// ZoneAware 区域感知负载均衡器
+// 在Build时根据Options.Zone自动包装在具体算法的外层，每个区域各自使用一个该算法的负载均衡器
+//
+// 流量分配：
+//  1. 本区域的健康度 health = min(1, 存活权重 / 总权重 * 超额配置系数)
+//  2. 以health的概率选择本区域，否则按其他区域的存活权重比例选择一个其他区域
+//  3. 选中的区域没有可用服务器时，依次尝试其他区域
+//
+// 服务器是否存活由Add/Remove决定，与健康检查的结果一致
+type ZoneAware struct {
+	sync.RWMutex
+
+	local  string
+	factor float64
+
+	// pools 包含所有区域，本区域排在第一个，其余按区域名称排序
+	pools  []*zonePool
+	poolOf map[string]*zonePool
+
+	factory Factory
+	opts    *Options
+
+	rnd *lockedRand
+}
+
+// newZoneAware 按区域分组创建每个区域的负载均衡器
+// 没有zone标签的服务器归入名称为空的区域，当作其他区域处理
+func newZoneAware(factory Factory, backends []*Backend, opts *Options) *ZoneAware {
+	factor := opts.OverprovisioningFactor
+	if factor <= 0 {
+		factor = DefaultOverprovisioningFactor
+	}
+	z := &ZoneAware{
+		local:   opts.Zone,
+		factor:  factor,
+		poolOf:  make(map[string]*zonePool),
+		factory: factory,
+		opts:    opts,
+		rnd:     newLockedRand(opts.seed()),
+	}
+
+	grouped := make(map[string][]*Backend)
+	for _, b := range backends {
+		zone := b.Labels[ZoneLabel]
+		grouped[zone] = append(grouped[zone], b)
+	}
+	zones := make([]string, 0, len(grouped))
+	for zone := range grouped {
+		zones = append(zones, zone)
+	}
+	sort.Slice(zones, func(i, j int) bool {
+		// 本区域排在最前面
+		if (zones[i] == z.local) != (zones[j] == z.local) {
+			return zones[i] == z.local
+		}
+		return zones[i] < zones[j]
+	})
+
+	for _, zone := range zones {
+		pool := z.newPool(zone, grouped[zone])
+		for host := range pool.weights {
+			z.poolOf[host] = pool
+		}
+		z.pools = append(z.pools, pool)
+	}
+	return z
+}
+
+// newPool 创建一个区域的负载均衡器
+// 指定了随机数种子时，每个区域使用不同的种子，避免各区域的随机选择序列完全相同
+func (z *ZoneAware) newPool(zone string, backends []*Backend) *zonePool {
+	opts := z.opts
+	if opts.Seed != 0 {
+		o := *opts
+		o.Seed += int64(len(z.pools) + 1)
+		opts = &o
+	}
+	lb := z.factory(backends, opts)
+	pool := &zonePool{
+		zone:    zone,
+		lb:      lb,
+		picker:  AsPicker(lb),
+		weights: make(map[string]int),
+		alive:   make(map[string]bool),
+	}
+	for _, b := range backends {
+		weight := b.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		pool.weights[b.Host] = weight
+		pool.alive[b.Host] = true
+		pool.total += weight
+		pool.healthy += weight
+	}
+	return pool
+}
+
+// Add 把服务器加回它所在区域的负载均衡器
+// 创建时没有出现过的服务器归入名称为空的区域，权重为1
+func (z *ZoneAware) Add(host string) {
+	z.Lock()
+	pool, ok := z.poolOf[host]
+	if !ok {
+		pool = z.otherPool()
+		pool.weights[host] = 1
+		pool.total++
+		z.poolOf[host] = pool
+	}
+	if !pool.alive[host] {
+		pool.alive[host] = true
+		pool.healthy += pool.weights[host]
+	}
+	z.Unlock()
+
+	pool.lb.Add(host)
+}
+
+// otherPool 返回名称为空的区域，不存在时创建
+// 调用者必须持有写锁
+func (z *ZoneAware) otherPool() *zonePool {
+	for _, pool := range z.pools {
+		if pool.zone == "" {
+			return pool
+		}
+	}
+	pool := z.newPool("", nil)
+	z.pools = append(z.pools, pool)
+	return pool
+}
+
+// Remove 把服务器从它所在区域的负载均衡器中移除
+func (z *ZoneAware) Remove(host string) {
+	z.Lock()
+	pool, ok := z.poolOf[host]
+	if ok && pool.alive[host] {
+		pool.alive[host] = false
+		pool.healthy -= pool.weights[host]
+	}
+	z.Unlock()
+
+	if ok {
+		pool.lb.Remove(host)
+	}
+}
+
+// Pick 按区域的健康度选择一个区域，由该区域的负载均衡器选择服务器
+// 选中的区域没有可用服务器，或者只能选到已尝试过的服务器时，依次尝试其他区域
+// 所有区域都只能选到已尝试过的服务器时，返回第一个选到的，与AsPicker的约定相同
+func (z *ZoneAware) Pick(info *PickInfo) (string, error) {
+	chosen := z.choose()
+
+	z.RLock()
+	pools := z.pools
+	z.RUnlock()
+
+	tried := ""
+	order := append([]*zonePool{chosen}, pools...)
+	for i, pool := range order {
+		if i > 0 && pool == chosen {
+			continue
+		}
+		host, err := pool.picker.Pick(info)
+		if errors.Is(err, NoHostError) {
+			continue
+		}
+		if err == nil && info.Tried[host] {
+			if tried == "" {
+				tried = host
+			}
+			continue
+		}
+		return host, err
+	}
+	if tried != "" {
+		return tried, nil
+	}
+	return "", NoHostError
+}
+
+// choose 选择本次请求使用的区域
+func (z *ZoneAware) choose() *zonePool {
+	z.RLock()
+	defer z.RUnlock()
+
+	var local *zonePool
+	others := 0
+	for _, pool := range z.pools {
+		if pool.zone == z.local {
+			local = pool
+		} else {
+			others += pool.healthy
+		}
+	}
+
+	// 本区域容量充足时全部留在本区域
+	if local != nil && local.total > 0 {
+		health := min(1, float64(local.healthy)/float64(local.total)*z.factor)
+		if health >= 1 || others == 0 || z.rnd.Float64() < health {
+			return local
+		}
+	}
+	if others == 0 {
+		return z.pools[0]
+	}
+
+	// 按其他区域的存活权重比例溢出
+	n := z.rnd.Intn(others)
+	for _, pool := range z.pools {
+		if pool == local {
+			continue
+		}
+		if n < pool.healthy {
+			return pool
+		}
+		n -= pool.healthy
+	}
+	return z.pools[0]
+}
+
+func (z *ZoneAware) Balance(key string) (string, error) {
+	return z.Pick(&PickInfo{Key: key})
+}
+
+// 以下方法把负载和请求结果转发给服务器所在区域的负载均衡器
+
+func (z *ZoneAware) pool(host string) *zonePool {
+	z.RLock()
+	defer z.RUnlock()
+	return z.poolOf[host]
+}
+
+func (z *ZoneAware) Inc(host string) {
+	if pool := z.pool(host); pool != nil {
+		pool.lb.Inc(host)
+	}
+}
+
+func (z *ZoneAware) Done(host string) {
+	if pool := z.pool(host); pool != nil {
+		pool.lb.Done(host)
+	}
+}
+
+func (z *ZoneAware) Report(host string, result Result) {
+	if pool := z.pool(host); pool != nil {
+		pool.lb.Report(host, result)
+	}
+}
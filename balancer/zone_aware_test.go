@@ -0,0 +1,36 @@
+package balancer
+
+import "testing"
+
+// TestZoneAwareSkipsTriedZone 本区域的服务器都已经尝试过时（如都达到了并发上限），请求溢出到其他区域
+func TestZoneAwareSkipsTriedZone(t *testing.T) {
+	backends := []*Backend{
+		{Host: "a1", Weight: 1, Labels: map[string]string{ZoneLabel: "a"}},
+		{Host: "a2", Weight: 1, Labels: map[string]string{ZoneLabel: "a"}},
+		{Host: "b1", Weight: 1, Labels: map[string]string{ZoneLabel: "b"}},
+	}
+	lb, err := Build(R2Balancer, backends, &Options{Zone: "a", Seed: 1})
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	picker := AsPicker(lb)
+
+	for i := 0; i < 10; i++ {
+		host, err := picker.Pick(&PickInfo{Tried: map[string]bool{"a1": true, "a2": true}})
+		if err != nil {
+			t.Fatalf("Pick: %v", err)
+		}
+		if host != "b1" {
+			t.Fatalf("Pick = %s, want b1 from the other zone", host)
+		}
+	}
+
+	tried := map[string]bool{"a1": true, "a2": true, "b1": true}
+	host, err := picker.Pick(&PickInfo{Tried: tried})
+	if err != nil {
+		t.Fatalf("Pick: %v", err)
+	}
+	if !tried[host] {
+		t.Fatalf("Pick = %s, want one of the tried hosts when every zone is exhausted", host)
+	}
+}
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"errors"
+)
+
+// CircuitBreaker 是location中每个后端服务器的熔断器配置
+//
+//	circuit_breaker:
+//	  window: 10
+//	  minimum_requests: 20
+//	  error_rate: 0.5
+//	  slow_call_duration: 1000
+//	  slow_call_rate: 0.8
+//	  open_duration: 30
+//	  half_open_requests: 3
+//
+// Window 统计错误率和慢请求比例的滑动窗口(秒),0表示使用默认值10
+// Minimum_requests 窗口内的请求数少于这个数时不会熔断,0表示使用默认值20
+// Error_rate 窗口内5xx或转发失败的比例达到这个值时熔断,0表示使用默认值0.5
+// Slow_call_duration 超过这个耗时(毫秒)的请求是慢请求,0表示不按延迟熔断
+// Slow_call_rate 窗口内慢请求的比例达到这个值时熔断,0表示使用默认值0.5
+// Open_duration 熔断后经过多久(秒)进入半开状态,0表示使用默认值30
+// Half_open_requests 半开状态最多同时放行的探测请求数,这些请求全部成功后恢复,0表示使用默认值3
+type CircuitBreaker struct {
+	Window             uint    `yaml:"window"`
+	Minimum_requests   uint    `yaml:"minimum_requests"`
+	Error_rate         float64 `yaml:"error_rate"`
+	Slow_call_duration uint    `yaml:"slow_call_duration"`
+	Slow_call_rate     float64 `yaml:"slow_call_rate"`
+	Open_duration      uint    `yaml:"open_duration"`
+	Half_open_requests uint    `yaml:"half_open_requests"`
+}
+
+// validate 检查熔断器配置的合理性
+func (c *CircuitBreaker) validate() error {
+	if c.Error_rate < 0 || c.Error_rate > 1 {
+		return errors.New("the circuit_breaker error_rate must be in [0, 1]")
+	}
+	if c.Slow_call_rate < 0 || c.Slow_call_rate > 1 {
+		return errors.New("the circuit_breaker slow_call_rate must be in [0, 1]")
+	}
+	return nil
+}
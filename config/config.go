@@ -2,8 +2,10 @@ package config
 
 import (
 	"errors"
+	"fku-balancer/balancer"
 	"fmt"
 	"os"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
@@ -20,6 +22,12 @@ var (
 // 负责读取、解析和使用变量存储配置文件中自定义的配置
 // SSLCertificateKey 当schema为https时,存储https的私钥文件路径
 // SSLCertificate 当schema为https时,存储https的证书文件路径
+// Zone 负载均衡器自己所在的可用区,配置后请求优先发给zone标签相同的后端服务器
+// Instance_id、Instance_count 本实例的编号和负载均衡器实例总数,实例总数大于1时每个实例只使用后端服务器的一个稳定子集
+// Health_check_rise、Health_check_fall 连续成功多少次才认为服务器恢复、连续失败多少次才认为服务器不可用,0表示1次
+// Health_check_jitter 探测间隔的随机抖动比例,如0.1表示间隔在 ±10% 内随机变化,避免所有探测同时发出
+// Health_check_unhealthy_interval 服务器不可用期间的探测间隔(秒),通常比health_check_interval短,0表示与它相同
+// Status_port 状态查询接口的端口,在 /status 上以JSON返回每个location的运行状态,0表示不开启
 type Config struct {
 	Schema                string      `yaml:"schema"`
 	Port                  int         `yaml:"port"`
@@ -29,12 +37,59 @@ type Config struct {
 	Location              []*Location `yaml:"location"`
 	SSLCertificateKey     string      `yaml:"ssl_certificate_key"`
 	SSLCertificate        string      `yaml:"ssl_certificate"`
+	Zone                  string      `yaml:"zone"`
+	Instance_id           uint        `yaml:"instance_id"`
+	Instance_count        uint        `yaml:"instance_count"`
+
+	Health_check_rise               uint    `yaml:"health_check_rise"`
+	Health_check_fall               uint    `yaml:"health_check_fall"`
+	Health_check_jitter             float64 `yaml:"health_check_jitter"`
+	Health_check_unhealthy_interval uint    `yaml:"health_check_unhealthy_interval"`
+
+	Status_port int `yaml:"status_port"`
 }
 
+// Hash_replicas 一致性哈希类算法每个服务器的虚拟节点数,0表示使用默认值
+// Hash_function 哈希类算法使用的哈希函数(crc32、fnv64a),为空表示使用默认值
+// Load_factor 有界负载一致性哈希(bounded)的负载因子,0表示使用默认值
+// Table_size maglev查找表的大小,会被调整为质数,0表示使用默认值
+// Hash_key 传给负载均衡算法的key,如 "header:X-User-Id"、"{cookie:sid}/{path}",为空表示使用客户端IP
+// Slow_start 慢启动时长(秒),服务器恢复或新加入后权重在这段时间内逐渐增加,0表示不开启
+// 只支持weighted-round-robin和rendezvous
+// Slow_start_aggression 慢启动权重增长曲线的指数,1表示线性增长,0表示使用默认值1
+// Overprovisioning_factor 优先级和区域感知路由的超额配置系数,0表示使用默认值1.4
+// 一个优先级(或本区域)中存活服务器的比例乘以这个系数小于100%时,不足的流量溢出到下一个优先级(或其他区域)
+// Subset_size 开启子集时每个实例使用的后端服务器数,0表示按 服务器数 / instance_count 向上取整
+// Adaptive_concurrency 是否开启每个后端服务器的自适应并发限制,上限根据延迟自动调整
+// 选中的服务器达到上限时改发给其他服务器,所有服务器都达到上限时返回503
+// Initial_concurrency、Max_concurrency 并发上限的初始值和最大值,0表示使用默认值20和1000
+// Health_check 该location的主动健康检查,为空时由全局的tcp_health_check决定是否使用TCP检查
+// Outlier_detection 该location的被动异常检测,为空表示不开启
+// Circuit_breaker 该location每个后端服务器的熔断器,为空表示不开启
 type Location struct {
-	Pattern      string   `yaml:"pattern"`
-	Proxy_pass   []string `yaml:"proxy_pass"`
-	Balance_mode string   `yaml:"balance_mode"`
+	Pattern       string       `yaml:"pattern"`
+	Proxy_pass    []*ProxyPass `yaml:"proxy_pass"`
+	Balance_mode  string       `yaml:"balance_mode"`
+	Hash_replicas int          `yaml:"hash_replicas"`
+	Hash_function string       `yaml:"hash_function"`
+	Load_factor   float64      `yaml:"load_factor"`
+	Table_size    int          `yaml:"table_size"`
+	Hash_key      string       `yaml:"hash_key"`
+
+	Slow_start            uint    `yaml:"slow_start"`
+	Slow_start_aggression float64 `yaml:"slow_start_aggression"`
+
+	Overprovisioning_factor float64 `yaml:"overprovisioning_factor"`
+
+	Subset_size int `yaml:"subset_size"`
+
+	Adaptive_concurrency bool `yaml:"adaptive_concurrency"`
+	Initial_concurrency  int  `yaml:"initial_concurrency"`
+	Max_concurrency      int  `yaml:"max_concurrency"`
+
+	Health_check      *HealthCheck      `yaml:"health_check"`
+	Outlier_detection *OutlierDetection `yaml:"outlier_detection"`
+	Circuit_breaker   *CircuitBreaker   `yaml:"circuit_breaker"`
 }
 
 func ReadConfig(filename string) (*Config, error) {
@@ -52,7 +107,7 @@ func ReadConfig(filename string) (*Config, error) {
 
 func (config *Config) Print() {
 	fmt.Println(sciiArt)
-	fmt.Printf("schema: %s\n port: %s\n, tcp_health_check: %s\n, health_check_interval: %s\n, max_allowed: %s\n",
+	fmt.Printf("schema: %s\n port: %d\n, tcp_health_check: %t\n, health_check_interval: %d\n, max_allowed: %d\n",
 		config.Schema, config.Port, config.Tcp_health_check, config.Health_check_interval, config.Max_allowed)
 
 	l := config.Location
@@ -72,7 +127,15 @@ func (c *Config) Validation() error {
 	}
 
 	if c.Port <= 1 {
-		fmt.Errorf("Port must be greater than 0")
+		return errors.New("port must be greater than 1")
+	}
+
+	if c.Status_port != 0 && (c.Status_port <= 1 || c.Status_port > 65535) {
+		return errors.New("status_port must be in (1, 65535]")
+	}
+
+	if c.Status_port == c.Port {
+		return errors.New("status_port must be different from port")
 	}
 
 	if len(c.Location) <= 0 {
@@ -84,5 +147,84 @@ func (c *Config) Validation() error {
 		return errors.New("health_check_interval must be greater than 0")
 	}
 
+	if c.Health_check_jitter < 0 || c.Health_check_jitter >= 1 {
+		return errors.New("health_check_jitter must be in [0, 1)")
+	}
+
+	if c.Instance_count > 0 && c.Instance_id >= c.Instance_count {
+		return errors.New("instance_id must be less than instance_count")
+	}
+
+	for _, l := range c.Location {
+		// 在加载配置时就检查算法是否注册,而不是等到创建代理时才报错
+		if !balancer.IsRegistered(l.Balance_mode) {
+			return fmt.Errorf("the balance_mode \"%s\" of location \"%s\" not supported, available: %s",
+				l.Balance_mode, l.Pattern, strings.Join(balancer.Algorithms(), ", "))
+		}
+		if _, err := balancer.GetHashFunc(l.Hash_function); err != nil {
+			return fmt.Errorf("the hash_function \"%s\" of location \"%s\" not supported", l.Hash_function, l.Pattern)
+		}
+		// hash_key 和 balance_mode 一样在加载配置时检查
+		if _, err := ParseHashKey(l.Hash_key); err != nil {
+			return fmt.Errorf("location \"%s\": %w", l.Pattern, err)
+		}
+		if len(l.Proxy_pass) <= 0 {
+			return fmt.Errorf("the proxy_pass of location \"%s\" cannot be null", l.Pattern)
+		}
+		for _, p := range l.Proxy_pass {
+			if len(p.Url) <= 0 {
+				return fmt.Errorf("the url of proxy_pass in location \"%s\" cannot be null", l.Pattern)
+			}
+			if p.Weight < 1 {
+				return fmt.Errorf("the weight of proxy_pass \"%s\" must be greater than 0", p.Url)
+			}
+			if p.Priority < 0 {
+				return fmt.Errorf("the priority of proxy_pass \"%s\" cannot be negative", p.Url)
+			}
+		}
+		if l.Hash_replicas < 0 {
+			return fmt.Errorf("the hash_replicas of location \"%s\" cannot be negative", l.Pattern)
+		}
+		if l.Load_factor != 0 && l.Load_factor <= 1 {
+			return fmt.Errorf("the load_factor of location \"%s\" must be greater than 1", l.Pattern)
+		}
+		if l.Table_size < 0 {
+			return fmt.Errorf("the table_size of location \"%s\" cannot be negative", l.Pattern)
+		}
+		// 只有按权重选择服务器的算法支持慢启动,其他算法配置了也不会生效
+		if l.Slow_start > 0 && l.Balance_mode != balancer.WeightedRoundRobinBalancer &&
+			l.Balance_mode != balancer.RendezvousBalancer {
+			return fmt.Errorf("the slow_start of location \"%s\" is only supported by %s and %s",
+				l.Pattern, balancer.WeightedRoundRobinBalancer, balancer.RendezvousBalancer)
+		}
+		if l.Slow_start_aggression < 0 {
+			return fmt.Errorf("the slow_start_aggression of location \"%s\" cannot be negative", l.Pattern)
+		}
+		if l.Overprovisioning_factor != 0 && l.Overprovisioning_factor < 1 {
+			return fmt.Errorf("the overprovisioning_factor of location \"%s\" cannot be less than 1", l.Pattern)
+		}
+		if l.Subset_size < 0 {
+			return fmt.Errorf("the subset_size of location \"%s\" cannot be negative", l.Pattern)
+		}
+		if l.Initial_concurrency < 0 || l.Max_concurrency < 0 {
+			return fmt.Errorf("the concurrency limits of location \"%s\" cannot be negative", l.Pattern)
+		}
+		if l.Health_check != nil {
+			if err := l.Health_check.validate(); err != nil {
+				return fmt.Errorf("location \"%s\": %w", l.Pattern, err)
+			}
+		}
+		if l.Outlier_detection != nil {
+			if err := l.Outlier_detection.validate(); err != nil {
+				return fmt.Errorf("location \"%s\": %w", l.Pattern, err)
+			}
+		}
+		if l.Circuit_breaker != nil {
+			if err := l.Circuit_breaker.validate(); err != nil {
+				return fmt.Errorf("location \"%s\": %w", l.Pattern, err)
+			}
+		}
+	}
+
 	return nil
 }
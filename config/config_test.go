@@ -0,0 +1,47 @@
+package config
+
+import (
+	"fku-balancer/balancer"
+	"strings"
+	"testing"
+)
+
+// TestValidationSlowStart slow_start只能用于支持慢启动的算法
+func TestValidationSlowStart(t *testing.T) {
+	tests := []struct {
+		mode    string
+		wantErr bool
+	}{
+		{mode: balancer.WeightedRoundRobinBalancer},
+		{mode: balancer.RendezvousBalancer},
+		{mode: balancer.R2Balancer, wantErr: true},
+		{mode: balancer.ConsistentHashBalancer, wantErr: true},
+		{mode: balancer.LeastLoadBalancer, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			c := validConfig(t, `    slow_start: 30
+    proxy_pass:
+      - "http://localhost:8001"`)
+			c.Location[0].Balance_mode = tt.mode
+			err := c.Validation()
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("Validation: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), "slow_start") {
+				t.Fatalf("Validation = %v, want an error about slow_start", err)
+			}
+		})
+	}
+
+	// 没有开启慢启动时任何算法都可以
+	c := validConfig(t, `    proxy_pass:
+      - "http://localhost:8001"`)
+	c.Location[0].Balance_mode = balancer.ConsistentHashBalancer
+	if err := c.Validation(); err != nil {
+		t.Fatalf("Validation without slow_start: %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// hash_key 支持的变量
+const (
+	HashKeyIP     = "ip"
+	HashKeyPath   = "path"
+	HashKeyHeader = "header"
+	HashKeyCookie = "cookie"
+	HashKeyQuery  = "query"
+)
+
+// HashKeyPart 是hash_key模板的一部分：Var为空时是普通文本Text，否则是一个变量
+// Arg 是变量的参数，如请求头、cookie、查询参数的名称
+type HashKeyPart struct {
+	Text string
+	Var  string
+	Arg  string
+}
+
+// ParseHashKey 解析location的hash_key配置
+//
+// 支持的变量:
+//   - ip: 客户端IP,与不配置hash_key时相同
+//   - path: 请求的URL路径
+//   - header:名称 请求头的值
+//   - cookie:名称 cookie的值
+//   - query:名称 URL查询参数的值
+//
+// 可以直接写一个变量(如 "header:X-User-Id"),也可以写成模板把多个变量和普通文本组合起来
+// (如 "{header:X-Tenant}/{path}")。为空时等同于 "ip"
+func ParseHashKey(spec string) ([]HashKeyPart, error) {
+	if spec == "" {
+		return []HashKeyPart{{Var: HashKeyIP}}, nil
+	}
+	template := spec
+	if !strings.Contains(template, "{") {
+		template = "{" + template + "}"
+	}
+
+	parts := make([]HashKeyPart, 0)
+	for rest := template; rest != ""; {
+		start := strings.Index(rest, "{")
+		if start == -1 {
+			parts = append(parts, HashKeyPart{Text: rest})
+			break
+		}
+		if start > 0 {
+			parts = append(parts, HashKeyPart{Text: rest[:start]})
+		}
+		end := strings.Index(rest[start:], "}")
+		if end == -1 {
+			return nil, fmt.Errorf("hash_key \"%s\": missing \"}\"", spec)
+		}
+		part, err := parseHashKeyVar(rest[start+1 : start+end])
+		if err != nil {
+			return nil, fmt.Errorf("hash_key \"%s\": %s", spec, err)
+		}
+		parts = append(parts, part)
+		rest = rest[start+end+1:]
+	}
+	return parts, nil
+}
+
+// parseHashKeyVar 解析 {} 中的一个变量
+func parseHashKeyVar(name string) (HashKeyPart, error) {
+	kind, arg, hasArg := strings.Cut(name, ":")
+	if hasArg && arg == "" {
+		return HashKeyPart{}, fmt.Errorf("variable \"%s\" requires a name", name)
+	}
+
+	switch kind {
+	case HashKeyIP, HashKeyPath:
+		if !hasArg {
+			return HashKeyPart{Var: kind}, nil
+		}
+	case HashKeyHeader, HashKeyCookie, HashKeyQuery:
+		if hasArg {
+			return HashKeyPart{Var: kind, Arg: arg}, nil
+		}
+	}
+	return HashKeyPart{}, fmt.Errorf("unknown variable \"%s\"", name)
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseHashKey(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []HashKeyPart
+	}{
+		{"", []HashKeyPart{{Var: HashKeyIP}}},
+		{"ip", []HashKeyPart{{Var: HashKeyIP}}},
+		{"path", []HashKeyPart{{Var: HashKeyPath}}},
+		{"header:X-User-Id", []HashKeyPart{{Var: HashKeyHeader, Arg: "X-User-Id"}}},
+		{"{cookie:sid}/{path}", []HashKeyPart{
+			{Var: HashKeyCookie, Arg: "sid"}, {Text: "/"}, {Var: HashKeyPath},
+		}},
+		{"tenant-{query:t}-end", []HashKeyPart{
+			{Text: "tenant-"}, {Var: HashKeyQuery, Arg: "t"}, {Text: "-end"},
+		}},
+	}
+	for _, tt := range tests {
+		got, err := ParseHashKey(tt.spec)
+		if err != nil {
+			t.Errorf("ParseHashKey(%q): %v", tt.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseHashKey(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestParseHashKeyErrors(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantErr string
+	}{
+		{"{header:X-User-Id", "missing \"}\""},
+		{"{path}/{ip", "missing \"}\""},
+		{"header:", "requires a name"},
+		{"{cookie:}", "requires a name"},
+		{"header", "unknown variable"},
+		{"path:x", "unknown variable"},
+		{"{user}", "unknown variable"},
+		{"{}", "unknown variable"},
+	}
+	for _, tt := range tests {
+		_, err := ParseHashKey(tt.spec)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("ParseHashKey(%q) = %v, want an error containing %q", tt.spec, err, tt.wantErr)
+		}
+	}
+}
+
+// TestValidationRejectsHashKey 无效的hash_key在加载配置时就报错
+func TestValidationRejectsHashKey(t *testing.T) {
+	c := validConfig(t, `    hash_key: "{header:X-User-Id"
+    proxy_pass:
+      - "http://localhost:8001"`)
+	err := c.Validation()
+	if err == nil || !strings.Contains(err.Error(), "hash_key") {
+		t.Fatalf("Validation = %v, want a hash_key error", err)
+	}
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// 健康检查的探测方式
+const (
+	// TcpProbe 建立TCP连接成功即认为服务器存活,与tcp_health_check相同
+	TcpProbe = "tcp"
+	// HttpProbe 发送HTTP请求检查响应,proxy_pass是https时使用HTTPS
+	HttpProbe = "http"
+)
+
+// DefaultExpectedStatus 是没有配置expected_status时认为健康的状态码范围
+var DefaultExpectedStatus = []string{"200-399"}
+
+// HealthCheck 是location的主动健康检查配置
+// 配置后该location使用这里的探测方式,不再受全局的tcp_health_check影响
+//
+//	health_check:
+//	  type: http
+//	  path: /healthz
+//	  method: GET
+//	  headers:
+//	    Host: api.example.com
+//	  expected_status: ["200", "300-399"]
+//	  body: "ok"
+//	  timeout: 2
+//	  rise: 2
+//	  fall: 3
+//
+// Type 探测方式(tcp、http),为空表示http
+// Path、Method、Headers HTTP探测的请求路径、方法和请求头,默认为 GET /
+// Expected_status 认为健康的状态码或状态码范围,默认为200-399
+// Body、Body_regex 响应体必须包含的字符串、必须匹配的正则表达式,为空表示不检查,不能与HEAD方法一起使用
+// Timeout 探测的超时时间(秒),0表示使用默认值3秒
+// Interval、Unhealthy_interval、Rise、Fall、Jitter 覆盖全局的health_check_*配置,0表示使用全局配置
+type HealthCheck struct {
+	Type            string            `yaml:"type"`
+	Path            string            `yaml:"path"`
+	Method          string            `yaml:"method"`
+	Headers         map[string]string `yaml:"headers"`
+	Expected_status []string          `yaml:"expected_status"`
+	Body            string            `yaml:"body"`
+	Body_regex      string            `yaml:"body_regex"`
+	Timeout         uint              `yaml:"timeout"`
+
+	Interval           uint    `yaml:"interval"`
+	Unhealthy_interval uint    `yaml:"unhealthy_interval"`
+	Rise               uint    `yaml:"rise"`
+	Fall               uint    `yaml:"fall"`
+	Jitter             float64 `yaml:"jitter"`
+}
+
+// StatusRange 是一个闭区间的状态码范围
+type StatusRange struct {
+	Min int
+	Max int
+}
+
+// Contains 判断状态码是否在范围内
+func (s StatusRange) Contains(code int) bool {
+	return code >= s.Min && code <= s.Max
+}
+
+// ProbeType 返回探测方式,为空时是http
+func (h *HealthCheck) ProbeType() string {
+	if h.Type == "" {
+		return HttpProbe
+	}
+	return h.Type
+}
+
+// StatusRanges 解析expected_status,支持 "200" 和 "200-299" 两种写法
+func (h *HealthCheck) StatusRanges() ([]StatusRange, error) {
+	expected := h.Expected_status
+	if len(expected) == 0 {
+		expected = DefaultExpectedStatus
+	}
+
+	ranges := make([]StatusRange, 0, len(expected))
+	for _, s := range expected {
+		lo, hi, isRange := strings.Cut(strings.TrimSpace(s), "-")
+		if !isRange {
+			hi = lo
+		}
+		min, err1 := strconv.Atoi(strings.TrimSpace(lo))
+		max, err2 := strconv.Atoi(strings.TrimSpace(hi))
+		if err1 != nil || err2 != nil || min < 100 || max > 599 || min > max {
+			return nil, fmt.Errorf("invalid expected_status \"%s\"", s)
+		}
+		ranges = append(ranges, StatusRange{Min: min, Max: max})
+	}
+	return ranges, nil
+}
+
+// validate 检查健康检查配置的合理性
+func (h *HealthCheck) validate() error {
+	if h.Jitter < 0 || h.Jitter >= 1 {
+		return errors.New("the health_check jitter must be in [0, 1)")
+	}
+
+	switch h.ProbeType() {
+	case TcpProbe:
+		return nil
+	case HttpProbe:
+	default:
+		return fmt.Errorf("the health_check type \"%s\" not supported", h.Type)
+	}
+
+	if h.Path != "" && !strings.HasPrefix(h.Path, "/") {
+		return fmt.Errorf("the health_check path \"%s\" must start with /", h.Path)
+	}
+	switch strings.ToUpper(h.Method) {
+	case "", http.MethodGet, http.MethodHead, http.MethodPost, http.MethodOptions:
+	default:
+		return fmt.Errorf("the health_check method \"%s\" not supported", h.Method)
+	}
+	// HEAD请求的响应没有响应体，配置了body或body_regex的检查永远不会成功
+	if strings.EqualFold(h.Method, http.MethodHead) && (h.Body != "" || h.Body_regex != "") {
+		return errors.New("the health_check method HEAD cannot be used with body or body_regex")
+	}
+	if _, err := h.StatusRanges(); err != nil {
+		return err
+	}
+	if _, err := regexp.Compile(h.Body_regex); err != nil {
+		return fmt.Errorf("invalid health_check body_regex: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"errors"
+)
+
+// OutlierDetection 是location的被动异常检测配置,根据代理转发的实际结果把异常的服务器暂时移出负载均衡器
+//
+//	outlier_detection:
+//	  consecutive_errors: 5
+//	  interval: 10
+//	  base_ejection_time: 30
+//	  max_ejection_percent: 10
+//
+// Consecutive_errors 连续多少个请求返回5xx或连接失败时移出服务器,0表示使用默认值5
+// Interval 统计成功率、恢复到期服务器的间隔(秒),0表示使用默认值10
+// Base_ejection_time 第一次移出的时长(秒),同一个服务器每次再被移出时时长翻倍,0表示使用默认值30
+// Max_ejection_time 移出时长的上限(秒),0表示使用默认值300
+// Max_ejection_percent 最多同时移出服务器的百分比,避免所有服务器都被移出,0表示使用默认值10
+// Success_rate_minimum_hosts 请求数足够的服务器少于这个数时不按成功率移出,0表示使用默认值5
+// Success_rate_request_volume 一个间隔内请求数少于这个数的服务器不参与成功率统计,0表示使用默认值100
+// Success_rate_stdev_factor 成功率低于 平均值 - 系数*标准差 的服务器被移出,0表示使用默认值1.9
+type OutlierDetection struct {
+	Consecutive_errors   uint `yaml:"consecutive_errors"`
+	Interval             uint `yaml:"interval"`
+	Base_ejection_time   uint `yaml:"base_ejection_time"`
+	Max_ejection_time    uint `yaml:"max_ejection_time"`
+	Max_ejection_percent uint `yaml:"max_ejection_percent"`
+
+	Success_rate_minimum_hosts  uint    `yaml:"success_rate_minimum_hosts"`
+	Success_rate_request_volume uint    `yaml:"success_rate_request_volume"`
+	Success_rate_stdev_factor   float64 `yaml:"success_rate_stdev_factor"`
+}
+
+// validate 检查异常检测配置的合理性
+func (o *OutlierDetection) validate() error {
+	if o.Max_ejection_percent > 100 {
+		return errors.New("the outlier_detection max_ejection_percent cannot be greater than 100")
+	}
+	if o.Success_rate_stdev_factor < 0 {
+		return errors.New("the outlier_detection success_rate_stdev_factor cannot be negative")
+	}
+	if o.Max_ejection_time != 0 && o.Max_ejection_time < o.Base_ejection_time {
+		return errors.New("the outlier_detection max_ejection_time cannot be less than base_ejection_time")
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ProxyPass 是location中的一个后端服务器
+// 配置文件中支持两种写法：
+//
+//	proxy_pass:
+//	  - "http://localhost:8001 weight=3"
+//	  - url: "http://localhost:8002"
+//	    weight: 1
+//	  - "http://localhost:8003 backup zone=us-east-1a"
+//	  - url: "http://localhost:8004"
+//	    labels:
+//	      zone: us-east-1b
+//	      region: us-east-1
+//
+// Weight 未配置时为1,显式配置的0会在Validation中被拒绝
+// Priority 优先级,0为主服务器,数字越大优先级越低,只有高优先级的服务器不够用时才会使用低优先级的服务器
+// Backup 与nginx的backup相同,是 priority=1 的简写
+// Labels 服务器的标签,如zone、region,字符串写法中可以直接写 zone=xxx、region=xxx
+type ProxyPass struct {
+	Url      string            `yaml:"url"`
+	Weight   int               `yaml:"weight"`
+	Priority int               `yaml:"priority"`
+	Backup   bool              `yaml:"backup"`
+	Labels   map[string]string `yaml:"labels"`
+
+	// weighted 表示配置中是否写了weight
+	weighted bool
+}
+
+// UnmarshalYAML 实现yaml.Unmarshaler接口，同时支持字符串和对象两种写法
+func (p *ProxyPass) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		var s string
+		if err := value.Decode(&s); err != nil {
+			return err
+		}
+		if err := p.parse(s); err != nil {
+			return err
+		}
+	} else {
+		// 使用别名类型解码，避免递归调用UnmarshalYAML
+		type plain ProxyPass
+		if err := value.Decode((*plain)(p)); err != nil {
+			return err
+		}
+		for i := 0; i+1 < len(value.Content); i += 2 {
+			if value.Content[i].Value == "weight" {
+				p.weighted = true
+			}
+		}
+	}
+
+	// 只有没有配置weight时才使用默认权重，weight=0 保留为0，由Validation报错
+	if !p.weighted {
+		p.Weight = 1
+	}
+	if p.Backup && p.Priority == 0 {
+		p.Priority = 1
+	}
+	return nil
+}
+
+// parse 解析 "url key=value ..." 形式的字符串
+func (p *ProxyPass) parse(s string) error {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return fmt.Errorf("proxy_pass cannot be empty")
+	}
+	p.Url = fields[0]
+
+	for _, field := range fields[1:] {
+		if field == "backup" {
+			p.Backup = true
+			continue
+		}
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return fmt.Errorf("proxy_pass \"%s\": invalid parameter \"%s\"", s, field)
+		}
+		switch key {
+		case "weight":
+			weight, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("proxy_pass \"%s\": invalid weight \"%s\"", s, value)
+			}
+			p.Weight = weight
+			p.weighted = true
+		case "priority":
+			priority, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("proxy_pass \"%s\": invalid priority \"%s\"", s, value)
+			}
+			p.Priority = priority
+		case "zone", "region":
+			if p.Labels == nil {
+				p.Labels = make(map[string]string)
+			}
+			p.Labels[key] = value
+		default:
+			return fmt.Errorf("proxy_pass \"%s\": unknown parameter \"%s\"", s, key)
+		}
+	}
+	return nil
+}
+
+func (p *ProxyPass) String() string {
+	s := fmt.Sprintf("%s weight=%d", p.Url, p.Weight)
+	if p.Priority > 0 {
+		s += fmt.Sprintf(" priority=%d", p.Priority)
+	}
+	for _, key := range []string{"zone", "region"} {
+		if value, ok := p.Labels[key]; ok {
+			s += fmt.Sprintf(" %s=%s", key, value)
+		}
+	}
+	return s
+}
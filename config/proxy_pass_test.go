@@ -0,0 +1,149 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestProxyPassUnmarshal(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want ProxyPass
+	}{
+		{
+			name: "string without weight",
+			yaml: `"http://localhost:8001"`,
+			want: ProxyPass{Url: "http://localhost:8001", Weight: 1},
+		},
+		{
+			name: "string with parameters",
+			yaml: `"http://localhost:8001 weight=3 priority=2 zone=us-east-1a region=us-east-1"`,
+			want: ProxyPass{Url: "http://localhost:8001", Weight: 3, Priority: 2,
+				Labels: map[string]string{"zone": "us-east-1a", "region": "us-east-1"}},
+		},
+		{
+			name: "string backup",
+			yaml: `"http://localhost:8001 backup"`,
+			want: ProxyPass{Url: "http://localhost:8001", Weight: 1, Priority: 1, Backup: true},
+		},
+		{
+			name: "string with explicit zero weight",
+			yaml: `"http://localhost:8001 weight=0"`,
+			want: ProxyPass{Url: "http://localhost:8001", Weight: 0},
+		},
+		{
+			name: "object without weight",
+			yaml: `{url: "http://localhost:8001"}`,
+			want: ProxyPass{Url: "http://localhost:8001", Weight: 1},
+		},
+		{
+			name: "object with parameters",
+			yaml: `{url: "http://localhost:8001", weight: 2, backup: true, labels: {zone: us-east-1b}}`,
+			want: ProxyPass{Url: "http://localhost:8001", Weight: 2, Priority: 1, Backup: true,
+				Labels: map[string]string{"zone": "us-east-1b"}},
+		},
+		{
+			name: "object with explicit zero weight",
+			yaml: `{url: "http://localhost:8001", weight: 0}`,
+			want: ProxyPass{Url: "http://localhost:8001", Weight: 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got ProxyPass
+			if err := yaml.Unmarshal([]byte(tt.yaml), &got); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			got.weighted = false
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxyPassUnmarshalErrors(t *testing.T) {
+	for _, s := range []string{
+		`""`,
+		`"http://localhost:8001 weight=abc"`,
+		`"http://localhost:8001 priority=x"`,
+		`"http://localhost:8001 weight"`,
+		`"http://localhost:8001 color=red"`,
+	} {
+		var p ProxyPass
+		if err := yaml.Unmarshal([]byte(s), &p); err == nil {
+			t.Errorf("Unmarshal(%s) succeeded, want an error", s)
+		}
+	}
+}
+
+// validConfig 返回一个只有一个location的合法配置
+func validConfig(t *testing.T, location string) *Config {
+	t.Helper()
+	in := `
+schema: http
+port: 8088
+health_check_interval: 3
+location:
+  - pattern: /
+    balance_mode: round-robin
+` + location
+	var c Config
+	if err := yaml.Unmarshal([]byte(in), &c); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	return &c
+}
+
+// TestValidationRejectsZeroWeight 显式配置的weight=0不会被当作默认权重1
+func TestValidationRejectsZeroWeight(t *testing.T) {
+	tests := []struct {
+		name     string
+		location string
+		wantErr  string
+	}{
+		{
+			name: "default weight",
+			location: `    proxy_pass:
+      - "http://localhost:8001"
+      - url: "http://localhost:8002"`,
+		},
+		{
+			name: "string zero weight",
+			location: `    proxy_pass:
+      - "http://localhost:8001 weight=0"`,
+			wantErr: "weight",
+		},
+		{
+			name: "object zero weight",
+			location: `    proxy_pass:
+      - url: "http://localhost:8001"
+        weight: 0`,
+			wantErr: "weight",
+		},
+		{
+			name: "negative weight",
+			location: `    proxy_pass:
+      - "http://localhost:8001 weight=-1"`,
+			wantErr: "weight",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validConfig(t, tt.location).Validation()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validation: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validation = %v, want an error about %s", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -17,8 +17,8 @@ var (
 func PathIsStart(config *config.Config) {
 
 	for _, l := range config.Location {
-		for _, path := range l.Proxy_pass {
-			pathIsStart[path] = false
+		for _, p := range l.Proxy_pass {
+			pathIsStart[p.Url] = false
 		}
 	}
 	startHost()
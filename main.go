@@ -5,8 +5,10 @@ import (
 	"fku-balancer/midWare"
 	"fku-balancer/proxy"
 	"fku-balancer/request"
+	"fku-balancer/simulate"
 	"log"
 	"net/http"
+	"os"
 	"strconv"
 	"time"
 
@@ -14,6 +16,14 @@ import (
 )
 
 func main() {
+	// 离线模拟负载均衡算法,不读取配置文件,也不启动代理
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		if err := simulate.Main(os.Args[2:]); err != nil {
+			log.Fatalf("simulate error: %s", err)
+		}
+		return
+	}
+
 	// 1读配置文件
 	config, err := config.ReadConfig("config/config.yaml")
 	if err != nil {
@@ -33,19 +43,30 @@ func main() {
 	// gorilla/mux是一个功能强大的URL路由器和调度器
 	router := mux.NewRouter()
 
+	// 状态查询接口汇总所有location的运行状态
+	status := proxy.NewStatus()
+
 	// 4为每个路由配置创建反向代理
 	for _, l := range config.Location {
-		httpProxy, err := proxy.NewHttpProxy(l.Proxy_pass, l.Balance_mode)
+		httpProxy, err := proxy.NewHttpProxy(config, l)
 
 		if err != nil {
 			log.Fatalf("create proxy error: %s", err)
 		}
 
-		if config.Tcp_health_check {
-			httpProxy.HealthCheck(config.Health_check_interval)
+		// location配置了health_check时使用它的探测方式，否则由全局的tcp_health_check决定
+		if config.Tcp_health_check || l.Health_check != nil {
+			httpProxy.HealthCheck()
 		}
 
+		// 熔断器状态变化写入日志
+		pattern := l.Pattern
+		httpProxy.OnBreakerTransition(func(t proxy.BreakerTransition) {
+			log.Printf("circuit breaker of %s in location %s: %s -> %s", t.Host, pattern, t.From, t.To)
+		})
+
 		router.Handle(l.Pattern, httpProxy)
+		status.Add(l.Pattern, httpProxy)
 	}
 
 	// 5添加中间件（如果配置了最大并发数）
@@ -71,6 +92,16 @@ func main() {
 	// 第九步：打印配置信息
 	config.Print()
 
+	// 状态查询接口使用单独的端口，不经过代理的中间件，也不暴露给代理的客户端
+	if config.Status_port > 0 {
+		statusMux := http.NewServeMux()
+		statusMux.Handle("/status", status)
+		go func() {
+			err := http.ListenAndServe(":"+strconv.Itoa(config.Status_port), statusMux)
+			log.Fatalf("status server error: %s", err)
+		}()
+	}
+
 	// 第十步：启动服务器监听
 
 	go func() {
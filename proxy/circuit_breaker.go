@@ -0,0 +1,312 @@
+package proxy
+
+import (
+	"fku-balancer/balancer"
+	"fku-balancer/config"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// 熔断器的默认参数
+const (
+	DefaultBreakerWindow          = 10 * time.Second
+	DefaultBreakerMinimumRequests = 20
+	DefaultBreakerErrorRate       = 0.5
+	DefaultBreakerSlowCallRate    = 0.5
+	DefaultBreakerOpenDuration    = 30 * time.Second
+	DefaultBreakerHalfOpenProbes  = 3
+
+	// breakerBuckets 滑动窗口划分的桶数，窗口每次滑动一个桶的时长
+	breakerBuckets = 10
+)
+
+// BreakerState 是熔断器的状态
+type BreakerState int
+
+const (
+	// BreakerClosed 正常转发请求
+	BreakerClosed BreakerState = iota
+	// BreakerOpen 熔断中，服务器被移出负载均衡器
+	BreakerOpen
+	// BreakerHalfOpen 放行少量探测请求，全部成功后恢复，任何一个失败就重新熔断
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("BreakerState(%d)", int(s))
+}
+
+// MarshalText 让状态在JSON中输出为名称而不是数字
+func (s BreakerState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// BreakerTransition 是一次熔断器状态变化
+type BreakerTransition struct {
+	Host string       `json:"host"`
+	From BreakerState `json:"from"`
+	To   BreakerState `json:"to"`
+	At   time.Time    `json:"at"`
+}
+
+// breakerPolicy 是同一个location中所有熔断器共用的参数
+type breakerPolicy struct {
+	bucket       time.Duration
+	minRequests  int
+	errorRate    float64
+	slowCall     time.Duration
+	slowCallRate float64
+	openDuration time.Duration
+	probes       int
+}
+
+// newBreakerPolicy 根据location的配置创建熔断参数，未配置的参数使用默认值
+func newBreakerPolicy(spec *config.CircuitBreaker) *breakerPolicy {
+	p := &breakerPolicy{
+		bucket:       DefaultBreakerWindow / breakerBuckets,
+		minRequests:  DefaultBreakerMinimumRequests,
+		errorRate:    DefaultBreakerErrorRate,
+		slowCall:     time.Duration(spec.Slow_call_duration) * time.Millisecond,
+		slowCallRate: DefaultBreakerSlowCallRate,
+		openDuration: DefaultBreakerOpenDuration,
+		probes:       DefaultBreakerHalfOpenProbes,
+	}
+	if spec.Window > 0 {
+		p.bucket = time.Duration(spec.Window) * time.Second / breakerBuckets
+	}
+	if spec.Minimum_requests > 0 {
+		p.minRequests = int(spec.Minimum_requests)
+	}
+	if spec.Error_rate > 0 {
+		p.errorRate = spec.Error_rate
+	}
+	if spec.Slow_call_rate > 0 {
+		p.slowCallRate = spec.Slow_call_rate
+	}
+	if spec.Open_duration > 0 {
+		p.openDuration = time.Duration(spec.Open_duration) * time.Second
+	}
+	if spec.Half_open_requests > 0 {
+		p.probes = int(spec.Half_open_requests)
+	}
+	return p
+}
+
+// breakerBucket 是滑动窗口中一个桶的统计数据
+type breakerBucket struct {
+	start    time.Time
+	requests int
+	failures int
+	slow     int
+}
+
+// circuitBreaker 是一个服务器的熔断器
+//
+// 状态变化：
+//  1. closed：滑动窗口内请求数达到minRequests，且错误率或慢请求比例达到阈值时变为open
+//  2. open：服务器被移出负载均衡器，经过openDuration后变为half-open
+//  3. half-open：服务器加回负载均衡器，最多同时放行probes个请求，
+//     累计probes个请求成功后变为closed，任何一个请求失败或变慢就重新变为open
+type circuitBreaker struct {
+	sync.Mutex
+
+	host   string
+	policy *breakerPolicy
+	state  BreakerState
+
+	buckets [breakerBuckets]breakerBucket
+
+	// inflight 半开状态下正在处理的探测请求数，passed 半开状态下成功的探测请求数
+	inflight int
+	passed   int
+	// generation 每次进入半开状态时加1，用来识别上一轮半开状态放行、现在才结束的探测请求
+	generation uint64
+
+	// notify 在状态变化后调用，不持有熔断器的锁
+	notify func(BreakerTransition)
+}
+
+func newCircuitBreaker(host string, policy *breakerPolicy, notify func(BreakerTransition)) *circuitBreaker {
+	return &circuitBreaker{host: host, policy: policy, notify: notify}
+}
+
+// allow 判断是否放行一个请求，probe表示这是半开状态的探测请求，generation是放行它的半开状态的轮次
+// 放行的请求结束后必须调用record，探测请求最终没有发出时必须调用cancel
+func (b *circuitBreaker) allow() (ok bool, probe bool, generation uint64) {
+	b.Lock()
+	defer b.Unlock()
+
+	switch b.state {
+	case BreakerClosed:
+		return true, false, 0
+	case BreakerHalfOpen:
+		if b.inflight < b.policy.probes {
+			b.inflight++
+			return true, true, b.generation
+		}
+	}
+	return false, false, 0
+}
+
+// cancel 释放没有发出的探测请求占用的位置
+func (b *circuitBreaker) cancel(generation uint64) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.state == BreakerHalfOpen && b.generation == generation && b.inflight > 0 {
+		b.inflight--
+	}
+}
+
+// record 记录一个请求的结果，并根据结果切换状态
+// 上一轮半开状态放行的探测请求结束时，熔断器已经重新熔断过，它的结果不再计入本轮
+func (b *circuitBreaker) record(result balancer.Result, probe bool, generation uint64) {
+	now := time.Now()
+	failed := result.Failed()
+	slow := b.policy.slowCall > 0 && result.Duration >= b.policy.slowCall
+
+	b.Lock()
+	var transition *BreakerTransition
+	switch {
+	case b.state == BreakerClosed:
+		bucket := b.bucketAt(now)
+		bucket.requests++
+		if failed {
+			bucket.failures++
+		}
+		if slow {
+			bucket.slow++
+		}
+		if b.shouldTrip(now) {
+			transition = b.transit(BreakerOpen, now)
+		}
+	case b.state == BreakerHalfOpen && probe && generation == b.generation:
+		b.inflight--
+		if failed || slow {
+			transition = b.transit(BreakerOpen, now)
+		} else if b.passed++; b.passed >= b.policy.probes {
+			transition = b.transit(BreakerClosed, now)
+		}
+	}
+	b.Unlock()
+
+	b.emit(transition)
+}
+
+// bucketAt 返回当前时间所在的桶，桶已经过期时清空
+// 调用者必须持有锁
+func (b *circuitBreaker) bucketAt(now time.Time) *breakerBucket {
+	start := now.Truncate(b.policy.bucket)
+	bucket := &b.buckets[start.UnixNano()/int64(b.policy.bucket)%breakerBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = breakerBucket{start: start}
+	}
+	return bucket
+}
+
+// shouldTrip 判断滑动窗口内的统计数据是否达到熔断条件
+// 调用者必须持有锁
+func (b *circuitBreaker) shouldTrip(now time.Time) bool {
+	requests, failures, slow := 0, 0, 0
+	window := b.policy.bucket * breakerBuckets
+	for _, bucket := range b.buckets {
+		if now.Sub(bucket.start) < window {
+			requests += bucket.requests
+			failures += bucket.failures
+			slow += bucket.slow
+		}
+	}
+	if requests < b.policy.minRequests {
+		return false
+	}
+	if float64(failures) >= float64(requests)*b.policy.errorRate {
+		return true
+	}
+	return b.policy.slowCall > 0 && float64(slow) >= float64(requests)*b.policy.slowCallRate
+}
+
+// transit 切换状态，进入open时安排在openDuration后进入half-open
+// 调用者必须持有锁
+func (b *circuitBreaker) transit(to BreakerState, now time.Time) *BreakerTransition {
+	from := b.state
+	b.state = to
+	b.inflight, b.passed = 0, 0
+
+	switch to {
+	case BreakerOpen:
+		time.AfterFunc(b.policy.openDuration, b.halfOpen)
+	case BreakerHalfOpen:
+		b.generation++
+	case BreakerClosed:
+		b.buckets = [breakerBuckets]breakerBucket{}
+	}
+	return &BreakerTransition{Host: b.host, From: from, To: to, At: now}
+}
+
+// halfOpen 熔断时间到期后进入半开状态
+func (b *circuitBreaker) halfOpen() {
+	b.Lock()
+	var transition *BreakerTransition
+	if b.state == BreakerOpen {
+		transition = b.transit(BreakerHalfOpen, time.Now())
+	}
+	b.Unlock()
+
+	b.emit(transition)
+}
+
+func (b *circuitBreaker) emit(transition *BreakerTransition) {
+	if transition != nil && b.notify != nil {
+		b.notify(*transition)
+	}
+}
+
+// snapshot 返回熔断器当前的状态
+func (b *circuitBreaker) snapshot() BreakerState {
+	b.Lock()
+	defer b.Unlock()
+
+	return b.state
+}
+
+// BreakerStates 返回每个服务器熔断器当前的状态，没有开启熔断器时返回nil
+func (h *HttpProxy) BreakerStates() map[string]BreakerState {
+	if h.breakers == nil {
+		return nil
+	}
+	states := make(map[string]BreakerState, len(h.breakers))
+	for host, breaker := range h.breakers {
+		states[host] = breaker.snapshot()
+	}
+	return states
+}
+
+// OnBreakerTransition 注册熔断器状态变化的回调，用于日志、监控等
+// 回调在状态变化的goroutine中同步调用，不应该阻塞
+func (h *HttpProxy) OnBreakerTransition(fn func(BreakerTransition)) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.breakerListeners = append(h.breakerListeners, fn)
+}
+
+// breakerTransition 熔断器状态变化时，open的服务器移出负载均衡器，其他状态加回来，并通知回调
+func (h *HttpProxy) breakerTransition(t BreakerTransition) {
+	h.update(t.Host, func() { h.broken[t.Host] = t.To == BreakerOpen })
+
+	h.RLock()
+	listeners := h.breakerListeners
+	h.RUnlock()
+	for _, fn := range listeners {
+		fn(t)
+	}
+}
@@ -0,0 +1,242 @@
+package proxy
+
+import (
+	"fku-balancer/balancer"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	succeeded = balancer.Result{StatusCode: 200, Duration: time.Millisecond}
+	failed    = balancer.Result{StatusCode: 502, Duration: time.Millisecond}
+)
+
+// openBreaker 让熔断器进入open状态
+func openBreaker(b *circuitBreaker) {
+	b.Lock()
+	b.transit(BreakerOpen, time.Now())
+	b.Unlock()
+}
+
+// TestBreakerIgnoresStaleProbe 上一轮半开状态放行的探测请求，在熔断器重新熔断并再次半开后才结束，
+// 它的结果不应该计入新一轮的探测
+func TestBreakerIgnoresStaleProbe(t *testing.T) {
+	b := newCircuitBreaker("h", &breakerPolicy{
+		bucket:       time.Second,
+		minRequests:  1,
+		errorRate:    0.5,
+		openDuration: time.Hour,
+		probes:       2,
+	}, nil)
+	openBreaker(b)
+	b.halfOpen()
+
+	_, staleProbe, staleGeneration := b.allow()
+	_, probe, generation := b.allow()
+	b.record(failed, probe, generation)
+	if got := b.snapshot(); got != BreakerOpen {
+		t.Fatalf("state after failed probe = %s, want open", got)
+	}
+	b.halfOpen()
+
+	b.record(succeeded, staleProbe, staleGeneration)
+	b.Lock()
+	inflight, passed := b.inflight, b.passed
+	b.Unlock()
+	if inflight != 0 || passed != 0 {
+		t.Fatalf("stale probe changed the new half-open round: inflight %d, passed %d", inflight, passed)
+	}
+
+	for i := 0; i < 2; i++ {
+		ok, probe, generation := b.allow()
+		if !ok || !probe {
+			t.Fatalf("probe %d not allowed in the new half-open round", i)
+		}
+		b.record(succeeded, probe, generation)
+	}
+	if got := b.snapshot(); got != BreakerClosed {
+		t.Fatalf("state after successful probes = %s, want closed", got)
+	}
+}
+
+// breakerStep 是熔断器测试的一步：依次发出results中的请求，然后等待wait，最后检查状态
+// 熔断器不放行的请求不会被记录
+type breakerStep struct {
+	results []balancer.Result
+	wait    time.Duration
+	want    BreakerState
+}
+
+func repeat(result balancer.Result, n int) []balancer.Result {
+	results := make([]balancer.Result, n)
+	for i := range results {
+		results[i] = result
+	}
+	return results
+}
+
+func TestBreakerStateMachine(t *testing.T) {
+	slow := balancer.Result{StatusCode: 200, Duration: 100 * time.Millisecond}
+	policy := func() *breakerPolicy {
+		return &breakerPolicy{
+			bucket:       10 * time.Millisecond,
+			minRequests:  4,
+			errorRate:    0.5,
+			slowCallRate: 0.5,
+			openDuration: 20 * time.Millisecond,
+			probes:       2,
+		}
+	}
+
+	tests := []struct {
+		name   string
+		policy func(*breakerPolicy)
+		steps  []breakerStep
+		// transitions 是期望的状态变化序列，nil表示不检查
+		transitions []BreakerState
+	}{
+		{
+			name: "below minimum requests",
+			steps: []breakerStep{
+				{results: repeat(failed, 3), want: BreakerClosed},
+			},
+		},
+		{
+			name: "trips on error rate",
+			steps: []breakerStep{
+				{results: append(repeat(succeeded, 2), failed), want: BreakerClosed},
+				{results: repeat(failed, 1), want: BreakerOpen},
+			},
+			transitions: []BreakerState{BreakerOpen},
+		},
+		{
+			name: "error rate below threshold",
+			steps: []breakerStep{
+				{results: append(repeat(succeeded, 7), repeat(failed, 3)...), want: BreakerClosed},
+			},
+		},
+		{
+			name:   "trips on slow calls",
+			policy: func(p *breakerPolicy) { p.slowCall = 50 * time.Millisecond },
+			steps: []breakerStep{
+				{results: append(repeat(succeeded, 2), repeat(slow, 2)...), want: BreakerOpen},
+			},
+		},
+		{
+			name: "slow calls ignored without slow_call_duration",
+			steps: []breakerStep{
+				{results: repeat(slow, 10), want: BreakerClosed},
+			},
+		},
+		{
+			name: "old buckets leave the window",
+			steps: []breakerStep{
+				{results: repeat(failed, 3), wait: 150 * time.Millisecond, want: BreakerClosed},
+				{results: append(repeat(succeeded, 2), failed), want: BreakerClosed},
+			},
+		},
+		{
+			name: "half-open probes close the breaker",
+			steps: []breakerStep{
+				{results: repeat(failed, 4), want: BreakerOpen},
+				{wait: 80 * time.Millisecond, want: BreakerHalfOpen},
+				{results: repeat(succeeded, 1), want: BreakerHalfOpen},
+				{results: repeat(succeeded, 1), want: BreakerClosed},
+			},
+			transitions: []BreakerState{BreakerOpen, BreakerHalfOpen, BreakerClosed},
+		},
+		{
+			name: "failed probe reopens the breaker",
+			steps: []breakerStep{
+				{results: repeat(failed, 4), want: BreakerOpen},
+				{wait: 80 * time.Millisecond, want: BreakerHalfOpen},
+				{results: repeat(failed, 1), want: BreakerOpen},
+				{wait: 80 * time.Millisecond, want: BreakerHalfOpen},
+			},
+			transitions: []BreakerState{BreakerOpen, BreakerHalfOpen, BreakerOpen, BreakerHalfOpen},
+		},
+		{
+			name:   "slow probe reopens the breaker",
+			policy: func(p *breakerPolicy) { p.slowCall = 50 * time.Millisecond },
+			steps: []breakerStep{
+				{results: repeat(failed, 4), want: BreakerOpen},
+				{wait: 80 * time.Millisecond, want: BreakerHalfOpen},
+				{results: repeat(slow, 1), want: BreakerOpen},
+			},
+		},
+		{
+			name: "closing resets the window",
+			steps: []breakerStep{
+				{results: repeat(failed, 4), want: BreakerOpen},
+				{wait: 80 * time.Millisecond, want: BreakerHalfOpen},
+				{results: repeat(succeeded, 2), want: BreakerClosed},
+				{results: repeat(failed, 3), want: BreakerClosed},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := policy()
+			if tt.policy != nil {
+				tt.policy(p)
+			}
+			// 熔断后的定时器可能在测试结束后才触发，所以用加锁的切片而不是channel记录状态变化
+			var mu sync.Mutex
+			var transitions []BreakerState
+			b := newCircuitBreaker("h", p, func(tr BreakerTransition) {
+				mu.Lock()
+				transitions = append(transitions, tr.To)
+				mu.Unlock()
+			})
+
+			for i, step := range tt.steps {
+				for _, result := range step.results {
+					if ok, probe, generation := b.allow(); ok {
+						b.record(result, probe, generation)
+					}
+				}
+				time.Sleep(step.wait)
+				if got := b.snapshot(); got != step.want {
+					t.Fatalf("step %d: state = %s, want %s", i, got, step.want)
+				}
+			}
+
+			if tt.transitions != nil {
+				mu.Lock()
+				got := append([]BreakerState(nil), transitions...)
+				mu.Unlock()
+				if !reflect.DeepEqual(got, tt.transitions) {
+					t.Errorf("transitions = %v, want %v", got, tt.transitions)
+				}
+			}
+		})
+	}
+}
+
+// TestBreakerHalfOpenProbeSlots 半开状态最多同时放行probes个请求，cancel释放没有发出的探测请求
+func TestBreakerHalfOpenProbeSlots(t *testing.T) {
+	b := newCircuitBreaker("h", &breakerPolicy{bucket: time.Second, openDuration: time.Hour, probes: 2}, nil)
+	if ok, probe, _ := b.allow(); !ok || probe {
+		t.Fatalf("closed breaker: allow = %t, probe = %t, want a normal request", ok, probe)
+	}
+	openBreaker(b)
+	if ok, _, _ := b.allow(); ok {
+		t.Fatal("open breaker allowed a request")
+	}
+	b.halfOpen()
+
+	_, _, generation := b.allow()
+	if ok, _, _ := b.allow(); !ok {
+		t.Fatal("second probe not allowed")
+	}
+	if ok, _, _ := b.allow(); ok {
+		t.Fatal("third probe allowed with probes = 2")
+	}
+	b.cancel(generation)
+	if ok, probe, _ := b.allow(); !ok || !probe {
+		t.Fatal("probe slot not released by cancel")
+	}
+}
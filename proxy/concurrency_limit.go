@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// 自适应并发限制的默认参数
+const (
+	// DefaultInitialConcurrency 每个服务器初始的并发上限
+	DefaultInitialConcurrency = 20
+	// DefaultMaxConcurrency 每个服务器并发上限的最大值
+	DefaultMaxConcurrency = 1000
+	// minConcurrency 并发上限的最小值,保证服务器恢复后还能收到请求
+	minConcurrency = 1
+	// rttProbeSamples 每隔多少个样本重新测量一次无负载时的延迟
+	// 避免后端变慢后一直和很久以前的最小延迟比较,导致上限越降越低
+	rttProbeSamples = 1000
+)
+
+// ConcurrencyLimit 是一个服务器当前的并发上限和正在处理的请求数
+type ConcurrencyLimit struct {
+	Limit    int `json:"limit"`
+	Inflight int `json:"inflight"`
+}
+
+// vegasLimit 是一个服务器的自适应并发上限,算法与TCP Vegas和Netflix concurrency-limits的Vegas相同
+//
+// 根据无负载时的延迟 rttNoLoad 和当前请求的延迟 rtt 估算服务器上排队的请求数:
+// queue = limit * (1 - rttNoLoad / rtt)
+//   - queue 小于 alpha 时服务器还有余量,上限增加 log10(limit)
+//   - queue 大于 beta 时服务器开始排队,上限减少 log10(limit)
+//   - 请求失败(转发错误或5xx)时上限减少 log10(limit)
+//
+// 其中 alpha = 3 * log10(limit), beta = 6 * log10(limit)
+type vegasLimit struct {
+	sync.Mutex
+
+	limit    float64
+	max      float64
+	inflight int
+
+	rttNoLoad time.Duration
+	samples   int
+}
+
+func newVegasLimit(initial, max int) *vegasLimit {
+	if max <= 0 {
+		max = DefaultMaxConcurrency
+	}
+	if initial <= 0 {
+		initial = DefaultInitialConcurrency
+	}
+	return &vegasLimit{
+		limit: math.Min(float64(initial), float64(max)),
+		max:   float64(max),
+	}
+}
+
+// acquire 在并发数没有达到上限时占用一个位置,返回是否成功
+func (v *vegasLimit) acquire() bool {
+	v.Lock()
+	defer v.Unlock()
+
+	if v.inflight >= int(v.limit) {
+		return false
+	}
+	v.inflight++
+	return true
+}
+
+// release 释放位置,并根据这次请求的延迟和结果调整上限
+func (v *vegasLimit) release(rtt time.Duration, failed bool) {
+	v.Lock()
+	defer v.Unlock()
+
+	inflight := v.inflight
+	v.inflight--
+
+	if rtt <= 0 {
+		return
+	}
+	v.samples++
+	if v.rttNoLoad == 0 || rtt < v.rttNoLoad || v.samples >= rttProbeSamples {
+		v.rttNoLoad = rtt
+		v.samples = 0
+	}
+
+	step := math.Max(1, math.Log10(v.limit))
+	switch {
+	case failed:
+		v.limit -= step
+	case float64(inflight)*2 < v.limit:
+		// 并发数远没有达到上限时,延迟不能说明上限是否合适
+		return
+	default:
+		queue := v.limit * (1 - float64(v.rttNoLoad)/float64(rtt))
+		if queue < 3*step {
+			v.limit += step
+		} else if queue > 6*step {
+			v.limit -= step
+		}
+	}
+	v.limit = math.Max(minConcurrency, math.Min(v.limit, v.max))
+}
+
+func (v *vegasLimit) snapshot() ConcurrencyLimit {
+	v.Lock()
+	defer v.Unlock()
+
+	return ConcurrencyLimit{Limit: int(v.limit), Inflight: v.inflight}
+}
+
+// ConcurrencyLimits 返回每个服务器当前的并发上限,没有开启自适应并发限制时返回nil
+func (h *HttpProxy) ConcurrencyLimits() map[string]ConcurrencyLimit {
+	if h.limits == nil {
+		return nil
+	}
+	limits := make(map[string]ConcurrencyLimit, len(h.limits))
+	for host, limit := range h.limits {
+		limits[host] = limit.snapshot()
+	}
+	return limits
+}
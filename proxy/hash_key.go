@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"fku-balancer/config"
+	"net/http"
+	"strings"
+)
+
+// hashKeyFunc 从请求中提取传给lb.Balance的key
+type hashKeyFunc func(r *http.Request) string
+
+// hashKeyVar 是hash_key中的一个变量,返回值为空表示请求中没有这个值
+type hashKeyVar func(r *http.Request) string
+
+// parseHashKey 根据location的hash_key配置创建提取key的函数,配置的语法见config.ParseHashKey
+// 任意一个变量在请求中不存在时,退回使用客户端IP,避免缺少这个值的请求全部落到同一台服务器上
+func parseHashKey(spec string) (hashKeyFunc, error) {
+	if spec == "" || spec == config.HashKeyIP {
+		return GetIP, nil
+	}
+	parsed, err := config.ParseHashKey(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	// parts 中的元素要么是普通文本(string),要么是变量(hashKeyVar)
+	parts := make([]any, 0, len(parsed))
+	for _, part := range parsed {
+		if part.Var == "" {
+			parts = append(parts, part.Text)
+		} else {
+			parts = append(parts, hashKeyVarOf(part))
+		}
+	}
+
+	return func(r *http.Request) string {
+		var sb strings.Builder
+		for _, part := range parts {
+			switch p := part.(type) {
+			case string:
+				sb.WriteString(p)
+			case hashKeyVar:
+				value := p(r)
+				if value == "" {
+					return GetIP(r)
+				}
+				sb.WriteString(value)
+			}
+		}
+		return sb.String()
+	}, nil
+}
+
+// hashKeyVarOf 返回读取一个变量的函数
+func hashKeyVarOf(part config.HashKeyPart) hashKeyVar {
+	arg := part.Arg
+	switch part.Var {
+	case config.HashKeyPath:
+		return func(r *http.Request) string {
+			return r.URL.Path
+		}
+	case config.HashKeyHeader:
+		return func(r *http.Request) string {
+			return r.Header.Get(arg)
+		}
+	case config.HashKeyCookie:
+		return func(r *http.Request) string {
+			c, err := r.Cookie(arg)
+			if err != nil {
+				return ""
+			}
+			return c.Value
+		}
+	case config.HashKeyQuery:
+		return func(r *http.Request) string {
+			return r.URL.Query().Get(arg)
+		}
+	}
+	return GetIP
+}
@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHashKey(t *testing.T) {
+	newRequest := func() *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/orders?t=acme", nil)
+		r.RemoteAddr = "192.0.2.7:51234"
+		r.Header.Set("X-User-Id", "u42")
+		r.AddCookie(&http.Cookie{Name: "sid", Value: "s1"})
+		return r
+	}
+
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"", "192.0.2.7"},
+		{"ip", "192.0.2.7"},
+		{"path", "/orders"},
+		{"header:X-User-Id", "u42"},
+		{"{cookie:sid}/{path}", "s1//orders"},
+		{"tenant-{query:t}", "tenant-acme"},
+		// 任意一个变量不存在时退回使用客户端IP
+		{"header:X-Missing", "192.0.2.7"},
+		{"{header:X-User-Id}/{cookie:missing}", "192.0.2.7"},
+	}
+	for _, tt := range tests {
+		key, err := parseHashKey(tt.spec)
+		if err != nil {
+			t.Fatalf("parseHashKey(%q): %v", tt.spec, err)
+		}
+		if got := key(newRequest()); got != tt.want {
+			t.Errorf("hash_key %q = %q, want %q", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestHashKeyInvalid(t *testing.T) {
+	if _, err := parseHashKey("{header:X"); err == nil {
+		t.Fatal("parseHashKey accepted an unclosed template")
+	}
+}
@@ -1,45 +1,124 @@
 package proxy
 
 import (
+	"fku-balancer/config"
+	"fmt"
+	"math/rand/v2"
 	"time"
 )
 
-func (h *HttpProxy) HealthCheck(interval uint) {
-	// 对每一个服务器都要进行健康检查
-	for host := range h.hostMap {
-		go hostHealthCheck(host, h, interval)
-	}
+// checkPolicy 决定探测的频率和服务器状态切换的条件
+type checkPolicy struct {
+	// interval 服务器存活时的探测间隔，unhealthyInterval 服务器不可用时的探测间隔
+	interval          time.Duration
+	unhealthyInterval time.Duration
+	// rise 连续成功多少次才认为服务器恢复，fall 连续失败多少次才认为服务器不可用
+	rise int
+	fall int
+	// jitter 探测间隔的随机抖动比例
+	jitter float64
 }
 
-func hostHealthCheck(host string, h *HttpProxy, interval uint) {
-	timeTicker := time.NewTicker(time.Duration(interval) * time.Second)
-	for range timeTicker.C {
-		if h.readAlive(host) && !IsBackendAlive(host) {
-			h.setAlive(host, false)
-			h.lb.Remove(host)
-		} else if !h.readAlive(host) && IsBackendAlive(host) {
-			h.setAlive(host, true)
-			h.lb.Add(host)
+// newCheckPolicy 合并全局配置和location的配置，location的非零值覆盖全局配置
+func newCheckPolicy(cfg *config.Config, spec *config.HealthCheck) *checkPolicy {
+	interval := cfg.Health_check_interval
+	unhealthy := cfg.Health_check_unhealthy_interval
+	rise, fall, jitter := cfg.Health_check_rise, cfg.Health_check_fall, cfg.Health_check_jitter
+	if spec != nil {
+		if spec.Interval > 0 {
+			interval = spec.Interval
+		}
+		if spec.Unhealthy_interval > 0 {
+			unhealthy = spec.Unhealthy_interval
+		}
+		if spec.Rise > 0 {
+			rise = spec.Rise
+		}
+		if spec.Fall > 0 {
+			fall = spec.Fall
 		}
+		if spec.Jitter > 0 {
+			jitter = spec.Jitter
+		}
+	}
+	interval = max(1, interval)
+	if unhealthy == 0 {
+		unhealthy = interval
+	}
+	return &checkPolicy{
+		interval:          time.Duration(interval) * time.Second,
+		unhealthyInterval: time.Duration(unhealthy) * time.Second,
+		rise:              int(max(1, rise)),
+		fall:              int(max(1, fall)),
+		jitter:            jitter,
 	}
 }
 
-// 读取后端服务器的存活状态
-func (h *HttpProxy) readAlive(host string) bool {
-	h.RLock()
+// next 返回下一次探测前的等待时间，在间隔的 ±jitter 范围内随机
+func (p *checkPolicy) next(alive bool) time.Duration {
+	d := p.interval
+	if !alive {
+		d = p.unhealthyInterval
+	}
+	if p.jitter > 0 {
+		d = time.Duration(float64(d) * (1 + p.jitter*(2*rand.Float64()-1)))
+	}
+	return d
+}
 
-	// 确保释放锁,即使发生panic,避免死锁
-	defer h.RUnlock()
+// String 返回探测策略的描述，参数相同的策略描述相同
+func (p *checkPolicy) String() string {
+	return fmt.Sprintf("interval=%s unhealthy_interval=%s rise=%d fall=%d jitter=%g",
+		p.interval, p.unhealthyInterval, p.rise, p.fall, p.jitter)
+}
 
-	return h.alive[host]
+// HealthCheck 按location配置的探测方式定期检查每个服务器，没有配置时使用TCP检查
+// 探测由DefaultHealthRegistry统一执行，多个location中相同的服务器和探测方式只探测一次
+func (h *HttpProxy) HealthCheck() {
+	DefaultHealthRegistry.Register(h)
 }
 
-// 设置后端服务器的存活状态
+// 设置后端服务器的存活状态，并同步到负载均衡器
 func (h *HttpProxy) setAlive(host string, alive bool) {
+	h.update(host, func() { h.alive[host] = alive })
+}
+
+// 设置后端服务器是否被异常检测移出，并同步到负载均衡器
+func (h *HttpProxy) setEjected(host string, ejected bool) {
+	h.update(host, func() { h.ejected[host] = ejected })
+}
+
+// update 修改服务器的状态，服务器是否可用发生变化时把它加入或移出负载均衡器
+// 健康检查、异常检测和熔断器各自独立地修改状态，只有都认为服务器正常时它才在负载均衡器中
+func (h *HttpProxy) update(host string, change func()) {
 	h.Lock()
 
 	// 确保释放锁,即使发生panic,避免死锁
 	defer h.Unlock()
 
-	h.alive[host] = alive
+	before := h.usable(host)
+	change()
+	after := h.usable(host)
+	if before == after {
+		return
+	}
+	if after {
+		h.lb.Add(host)
+	} else {
+		h.lb.Remove(host)
+	}
+}
+
+// usable 服务器是否应该在负载均衡器中
+// 调用者必须持有锁
+func (h *HttpProxy) usable(host string) bool {
+	return h.alive[host] && !h.ejected[host] && !h.broken[host]
+}
+
+// available 读取服务器是否可用，供按健康度分配流量的优先级使用
+func (h *HttpProxy) available(host string) bool {
+	h.RLock()
+	defer h.RUnlock()
+
+	return h.usable(host)
 }
@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"net/url"
+	"sync"
+	"time"
+)
+
+// DefaultHealthRegistry 是HttpProxy.HealthCheck使用的健康检查注册表
+var DefaultHealthRegistry = NewHealthRegistry()
+
+// healthKey 标识一个健康检查：服务器地址加上探测方式和探测策略
+// 地址包含scheme，同一个服务器的HTTP和HTTPS探测是两个检查
+type healthKey struct {
+	target string
+	spec   string
+}
+
+// healthSubscriber 是订阅了健康检查结果的一个HttpProxy中的服务器
+type healthSubscriber struct {
+	proxy *HttpProxy
+	host  string
+}
+
+// sharedCheck 是多个HttpProxy共用的一个健康检查
+type sharedCheck struct {
+	target *url.URL
+	prober prober
+	policy *checkPolicy
+
+	// alive 是检查得出的服务器状态，subscribers 在状态变化时收到通知
+	alive       bool
+	subscribers []healthSubscriber
+}
+
+// HealthRegistry 健康检查注册表
+// 按 服务器地址+探测方式+探测策略 合并健康检查：同一个服务器出现在多个location中、
+// 并且探测配置相同时只探测一次，状态变化时通知所有订阅的HttpProxy，保证它们看到的状态一致
+type HealthRegistry struct {
+	sync.Mutex
+
+	checks map[healthKey]*sharedCheck
+}
+
+// NewHealthRegistry 创建健康检查注册表
+func NewHealthRegistry() *HealthRegistry {
+	return &HealthRegistry{checks: make(map[healthKey]*sharedCheck)}
+}
+
+// Register 为HttpProxy的每个服务器订阅健康检查，检查不存在时创建并开始探测
+// 检查已经存在并且认为服务器不可用时，新订阅的HttpProxy立即把服务器移出负载均衡器
+func (r *HealthRegistry) Register(h *HttpProxy) {
+	spec := h.prober.spec() + " " + h.policy.String()
+
+	for host, target := range h.targets {
+		key := healthKey{target: target.Scheme + "://" + GetHost(target), spec: spec}
+
+		r.Lock()
+		check, ok := r.checks[key]
+		if !ok {
+			check = &sharedCheck{target: target, prober: h.prober, policy: h.policy, alive: true}
+			r.checks[key] = check
+			go r.run(check)
+		}
+		check.subscribers = append(check.subscribers, healthSubscriber{proxy: h, host: host})
+		// 在持有锁时同步状态，避免与publish的通知交错
+		if !check.alive {
+			h.setAlive(host, false)
+		}
+		r.Unlock()
+	}
+}
+
+// Checks 返回注册表中的健康检查数量
+func (r *HealthRegistry) Checks() int {
+	r.Lock()
+	defer r.Unlock()
+
+	return len(r.checks)
+}
+
+// run 定期探测一个服务器
+// 连续失败fall次才认为服务器不可用，连续成功rise次才认为它恢复，避免服务器状态来回抖动
+func (r *HealthRegistry) run(check *sharedCheck) {
+	successes, failures := 0, 0
+	alive := true
+	timer := time.NewTimer(check.policy.next(alive))
+	for range timer.C {
+		if check.prober.probe(check.target) {
+			successes, failures = successes+1, 0
+		} else {
+			successes, failures = 0, failures+1
+		}
+
+		if alive && failures >= check.policy.fall {
+			alive = false
+			r.publish(check, false)
+		} else if !alive && successes >= check.policy.rise {
+			alive = true
+			r.publish(check, true)
+		}
+		timer.Reset(check.policy.next(alive))
+	}
+}
+
+// publish 记录服务器的新状态，并通知所有订阅者
+func (r *HealthRegistry) publish(check *sharedCheck, alive bool) {
+	r.Lock()
+	check.alive = alive
+	subscribers := append([]healthSubscriber(nil), check.subscribers...)
+	r.Unlock()
+
+	for _, s := range subscribers {
+		s.proxy.setAlive(s.host, alive)
+	}
+}
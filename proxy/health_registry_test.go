@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"fku-balancer/balancer"
+	"fku-balancer/config"
+	"testing"
+)
+
+// newIdleProxy 创建一个健康检查间隔很长的HttpProxy，测试期间不会真正发出探测
+func newIdleProxy(t *testing.T, pattern string, health *config.HealthCheck, backends ...string) *HttpProxy {
+	t.Helper()
+	location := &config.Location{Pattern: pattern, Balance_mode: balancer.R2Balancer, Health_check: health}
+	for _, backend := range backends {
+		location.Proxy_pass = append(location.Proxy_pass, &config.ProxyPass{Url: backend, Weight: 1})
+	}
+	h, err := NewHttpProxy(&config.Config{Health_check_interval: 3600}, location)
+	if err != nil {
+		t.Fatalf("NewHttpProxy: %v", err)
+	}
+	return h
+}
+
+// checkOf 返回注册表中探测target的健康检查
+func checkOf(t *testing.T, r *HealthRegistry, target string) *sharedCheck {
+	t.Helper()
+	r.Lock()
+	defer r.Unlock()
+
+	for key, check := range r.checks {
+		if key.target == target {
+			return check
+		}
+	}
+	t.Fatalf("no health check for %s", target)
+	return nil
+}
+
+// TestHealthRegistryShare 两个location共用的服务器探测方式相同时只有一个健康检查，状态变化通知到两个location
+func TestHealthRegistryShare(t *testing.T) {
+	const shared, other = "10.255.0.1:80", "10.255.0.2:80"
+	r := NewHealthRegistry()
+	p1 := newIdleProxy(t, "/a", nil, "http://"+shared, "http://"+other)
+	p2 := newIdleProxy(t, "/b", nil, "http://"+shared)
+	r.Register(p1)
+	r.Register(p2)
+
+	if got := r.Checks(); got != 2 {
+		t.Fatalf("registry has %d checks, want 2", got)
+	}
+
+	check := checkOf(t, r, "http://"+shared)
+	r.publish(check, false)
+	if p1.available(shared) || p2.available(shared) {
+		t.Fatal("shared host still usable after the check failed")
+	}
+	if !p1.available(other) {
+		t.Fatal("unrelated host marked unusable")
+	}
+	if host, err := p1.lb.Balance(""); err != nil || host != other {
+		t.Fatalf("p1 Balance = %s, %v, want %s", host, err, other)
+	}
+	if _, err := p2.lb.Balance(""); err != balancer.NoHostError {
+		t.Fatalf("p2 Balance error = %v, want NoHostError", err)
+	}
+
+	r.publish(check, true)
+	if !p1.available(shared) || !p2.available(shared) {
+		t.Fatal("shared host not restored after the check recovered")
+	}
+}
+
+// TestHealthRegistrySeparateSpecs 探测方式不同时各自探测
+func TestHealthRegistrySeparateSpecs(t *testing.T) {
+	r := NewHealthRegistry()
+	r.Register(newIdleProxy(t, "/a", nil, "http://10.255.0.1:80"))
+	r.Register(newIdleProxy(t, "/b", &config.HealthCheck{Path: "/healthz"}, "http://10.255.0.1:80"))
+	if got := r.Checks(); got != 2 {
+		t.Fatalf("registry has %d checks, want 2", got)
+	}
+}
+
+// TestHealthRegistryLateSubscriber 订阅一个已经认为服务器不可用的检查时，立即把服务器移出
+func TestHealthRegistryLateSubscriber(t *testing.T) {
+	const host = "10.255.0.1:80"
+	r := NewHealthRegistry()
+	r.Register(newIdleProxy(t, "/a", nil, "http://"+host))
+	r.publish(checkOf(t, r, "http://"+host), false)
+
+	late := newIdleProxy(t, "/b", nil, "http://"+host)
+	r.Register(late)
+	if late.available(host) {
+		t.Fatal("late subscriber still considers the dead host usable")
+	}
+	if r.Checks() != 1 {
+		t.Fatalf("registry has %d checks, want 1", r.Checks())
+	}
+}
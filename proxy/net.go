@@ -5,6 +5,7 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -42,18 +43,17 @@ func GetHost(url *url.URL) string {
 	return url.Host
 }
 
-// IsBackendAlive 检查后端服务是否存活
-// 通过尝试建立 TCP 连接来判断目标主机是否可访问
-func IsBackendAlive(host string) bool {
+// dialBackend 在超时时间内建立 TCP 连接，成功即认为主机存活
+func dialBackend(host string, timeout time.Duration) bool {
 	// 解析主机地址为 TCP 地址
 	addr, err := net.ResolveTCPAddr("tcp", host)
 	if err != nil {
 		return false
 	}
 
-	resolveAddr := fmt.Sprintf("%s:%d", addr.IP, addr.Port)
+	resolveAddr := net.JoinHostPort(addr.IP.String(), strconv.Itoa(addr.Port))
 
-	conn, err := net.DialTimeout("tcp", resolveAddr, ConnectionTimeout)
+	conn, err := net.DialTimeout("tcp", resolveAddr, timeout)
 	if err != nil {
 		return false
 	}
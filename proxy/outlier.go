@@ -0,0 +1,215 @@
+package proxy
+
+import (
+	"fku-balancer/balancer"
+	"fku-balancer/config"
+	"math"
+	"sync"
+	"time"
+)
+
+// 被动异常检测的默认参数，与Envoy的默认值相同
+const (
+	DefaultConsecutiveErrors       = 5
+	DefaultOutlierInterval         = 10 * time.Second
+	DefaultBaseEjectionTime        = 30 * time.Second
+	DefaultMaxEjectionTime         = 300 * time.Second
+	DefaultMaxEjectionPercent      = 10
+	DefaultSuccessRateMinimumHosts = 5
+	DefaultSuccessRateVolume       = 100
+	DefaultSuccessRateStdevFactor  = 1.9
+)
+
+// outlierHost 是一个服务器的异常检测状态
+type outlierHost struct {
+	// consecutive 连续失败的请求数
+	consecutive int
+	// requests、successes 当前统计间隔内的请求数和成功数
+	requests  int
+	successes int
+
+	ejected    bool
+	ejectedAt  time.Time
+	ejectedFor time.Duration
+	// ejections 最近被移出的次数，决定下一次移出的时长，服务器一直正常时逐渐减少
+	ejections int
+}
+
+// outlierDetector 被动异常检测，与Envoy的outlier detection相同
+//
+// 根据代理转发的实际结果移出异常的服务器：
+//  1. 连续 consecutive 个请求返回5xx或连接失败时立即移出
+//  2. 每个统计间隔结束时，成功率低于 平均值 - stdevFactor*标准差 的服务器被移出
+//  3. 移出的时长为 baseEjection * 2^(最近被移出的次数-1)，不超过maxEjection，到期后自动恢复
+//  4. 同时被移出的服务器不超过 maxPercent%（至少允许移出1个），避免所有服务器都被移出
+type outlierDetector struct {
+	sync.Mutex
+
+	consecutive  int
+	interval     time.Duration
+	baseEjection time.Duration
+	maxEjection  time.Duration
+	maxPercent   int
+
+	minHosts    int
+	volume      int
+	stdevFactor float64
+
+	hosts map[string]*outlierHost
+	// eject 把服务器移出或加回负载均衡器
+	eject func(host string, ejected bool)
+}
+
+// newOutlierDetector 根据location的配置创建异常检测，未配置的参数使用默认值
+func newOutlierDetector(spec *config.OutlierDetection, hosts []string, eject func(string, bool)) *outlierDetector {
+	o := &outlierDetector{
+		consecutive:  DefaultConsecutiveErrors,
+		interval:     DefaultOutlierInterval,
+		baseEjection: DefaultBaseEjectionTime,
+		maxEjection:  DefaultMaxEjectionTime,
+		maxPercent:   DefaultMaxEjectionPercent,
+		minHosts:     DefaultSuccessRateMinimumHosts,
+		volume:       DefaultSuccessRateVolume,
+		stdevFactor:  DefaultSuccessRateStdevFactor,
+		hosts:        make(map[string]*outlierHost, len(hosts)),
+		eject:        eject,
+	}
+	if spec.Consecutive_errors > 0 {
+		o.consecutive = int(spec.Consecutive_errors)
+	}
+	if spec.Interval > 0 {
+		o.interval = time.Duration(spec.Interval) * time.Second
+	}
+	if spec.Base_ejection_time > 0 {
+		o.baseEjection = time.Duration(spec.Base_ejection_time) * time.Second
+	}
+	if spec.Max_ejection_time > 0 {
+		o.maxEjection = time.Duration(spec.Max_ejection_time) * time.Second
+	}
+	if spec.Max_ejection_percent > 0 {
+		o.maxPercent = int(spec.Max_ejection_percent)
+	}
+	if spec.Success_rate_minimum_hosts > 0 {
+		o.minHosts = int(spec.Success_rate_minimum_hosts)
+	}
+	if spec.Success_rate_request_volume > 0 {
+		o.volume = int(spec.Success_rate_request_volume)
+	}
+	if spec.Success_rate_stdev_factor > 0 {
+		o.stdevFactor = spec.Success_rate_stdev_factor
+	}
+	for _, host := range hosts {
+		o.hosts[host] = &outlierHost{}
+	}
+	return o
+}
+
+// report 记录一个请求的结果，连续失败达到阈值时移出服务器
+func (o *outlierDetector) report(host string, result balancer.Result) {
+	o.Lock()
+	defer o.Unlock()
+
+	h, ok := o.hosts[host]
+	if !ok {
+		return
+	}
+	h.requests++
+	if !result.Failed() {
+		h.successes++
+		h.consecutive = 0
+		return
+	}
+	h.consecutive++
+	if h.consecutive >= o.consecutive {
+		o.tryEject(host, h, time.Now())
+	}
+}
+
+// tryEject 在不超过最大移出比例时移出服务器
+// 调用者必须持有锁
+func (o *outlierDetector) tryEject(host string, h *outlierHost, now time.Time) {
+	if h.ejected {
+		return
+	}
+	ejected := 0
+	for _, other := range o.hosts {
+		if other.ejected {
+			ejected++
+		}
+	}
+	limit := len(o.hosts) * o.maxPercent / 100
+	if limit < 1 && len(o.hosts) > 1 {
+		limit = 1
+	}
+	if ejected >= limit {
+		return
+	}
+
+	h.ejections++
+	h.ejected = true
+	h.ejectedAt = now
+	h.ejectedFor = o.baseEjection * time.Duration(1<<min(h.ejections-1, 30))
+	if h.ejectedFor > o.maxEjection || h.ejectedFor <= 0 {
+		h.ejectedFor = o.maxEjection
+	}
+	h.consecutive = 0
+	o.eject(host, true)
+}
+
+// run 每个统计间隔恢复移出到期的服务器，并按成功率移出异常的服务器
+func (o *outlierDetector) run() {
+	ticker := time.NewTicker(o.interval)
+	for now := range ticker.C {
+		o.sweep(now)
+	}
+}
+
+// sweep 执行一次统计间隔结束时的检查
+func (o *outlierDetector) sweep(now time.Time) {
+	o.Lock()
+	defer o.Unlock()
+
+	for host, h := range o.hosts {
+		if h.ejected && now.Sub(h.ejectedAt) >= h.ejectedFor {
+			h.ejected = false
+			o.eject(host, false)
+		} else if !h.ejected && h.ejections > 0 && h.consecutive == 0 {
+			// 服务器恢复后一直正常，下一次移出的时长逐渐回到baseEjection
+			h.ejections--
+		}
+	}
+
+	o.successRate(now)
+
+	for _, h := range o.hosts {
+		h.requests, h.successes = 0, 0
+	}
+}
+
+// successRate 移出成功率明显低于其他服务器的服务器
+// 调用者必须持有锁
+func (o *outlierDetector) successRate(now time.Time) {
+	rates := make(map[string]float64)
+	sum := 0.0
+	for host, h := range o.hosts {
+		if !h.ejected && h.requests >= o.volume {
+			rates[host] = float64(h.successes) / float64(h.requests)
+			sum += rates[host]
+		}
+	}
+	if len(rates) < o.minHosts || len(rates) == 0 {
+		return
+	}
+
+	mean := sum / float64(len(rates))
+	variance := 0.0
+	for _, rate := range rates {
+		variance += (rate - mean) * (rate - mean)
+	}
+	threshold := mean - o.stdevFactor*math.Sqrt(variance/float64(len(rates)))
+	for host, rate := range rates {
+		if rate < threshold {
+			o.tryEject(host, o.hosts[host], now)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package proxy
+
+import (
+	"fku-balancer/config"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// fakeEjector 记录异常检测移出和加回的服务器
+type fakeEjector map[string]bool
+
+func (f fakeEjector) eject(host string, ejected bool) {
+	f[host] = ejected
+}
+
+func (f fakeEjector) count() int {
+	n := 0
+	for _, ejected := range f {
+		if ejected {
+			n++
+		}
+	}
+	return n
+}
+
+func newTestOutlier(spec config.OutlierDetection, n int) (*outlierDetector, fakeEjector) {
+	hosts := make([]string, n)
+	for i := range hosts {
+		hosts[i] = fmt.Sprintf("h%d", i)
+	}
+	ejector := make(fakeEjector)
+	return newOutlierDetector(&spec, hosts, ejector.eject), ejector
+}
+
+func reportN(o *outlierDetector, host string, result []bool) {
+	for _, ok := range result {
+		if ok {
+			o.report(host, succeeded)
+		} else {
+			o.report(host, failed)
+		}
+	}
+}
+
+func TestOutlierConsecutiveErrors(t *testing.T) {
+	o, ejector := newTestOutlier(config.OutlierDetection{Consecutive_errors: 3, Max_ejection_percent: 100}, 4)
+
+	// 中间有一次成功时重新计数
+	reportN(o, "h0", []bool{false, false, true, false, false})
+	if ejector["h0"] {
+		t.Fatal("h0 ejected without 3 consecutive errors")
+	}
+	reportN(o, "h0", []bool{false})
+	if !ejector["h0"] {
+		t.Fatal("h0 not ejected after 3 consecutive errors")
+	}
+	// 未知的服务器被忽略
+	reportN(o, "unknown", []bool{false, false, false})
+	if _, ok := ejector["unknown"]; ok {
+		t.Fatal("unknown host ejected")
+	}
+}
+
+func TestOutlierMaxEjectionPercent(t *testing.T) {
+	tests := []struct {
+		name    string
+		hosts   int
+		percent uint
+		want    int
+	}{
+		{"percent of hosts", 10, 20, 2},
+		{"at least one", 5, 10, 1},
+		{"never the only host", 1, 10, 0},
+		{"all hosts", 3, 100, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, ejector := newTestOutlier(config.OutlierDetection{
+				Consecutive_errors:   1,
+				Max_ejection_percent: tt.percent,
+			}, tt.hosts)
+			for i := 0; i < tt.hosts; i++ {
+				reportN(o, fmt.Sprintf("h%d", i), []bool{false})
+			}
+			if got := ejector.count(); got != tt.want {
+				t.Fatalf("ejected %d hosts, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestOutlierEjectionTime 移出时长按移出次数指数增长，不超过max_ejection_time，一直正常后逐渐回落
+func TestOutlierEjectionTime(t *testing.T) {
+	o, ejector := newTestOutlier(config.OutlierDetection{
+		Consecutive_errors:   1,
+		Base_ejection_time:   10,
+		Max_ejection_time:    35,
+		Max_ejection_percent: 100,
+	}, 2)
+
+	for _, want := range []time.Duration{10 * time.Second, 20 * time.Second, 35 * time.Second, 35 * time.Second} {
+		reportN(o, "h0", []bool{false})
+		h := o.hosts["h0"]
+		if !ejector["h0"] || h.ejectedFor != want {
+			t.Fatalf("ejected %t for %s, want ejected for %s", ejector["h0"], h.ejectedFor, want)
+		}
+
+		o.sweep(h.ejectedAt.Add(want - time.Millisecond))
+		if !ejector["h0"] {
+			t.Fatalf("h0 restored before its %s ejection expired", want)
+		}
+		o.sweep(h.ejectedAt.Add(want))
+		if ejector["h0"] {
+			t.Fatalf("h0 not restored after %s", want)
+		}
+	}
+
+	// 恢复后每个正常的统计间隔让移出次数减1
+	ejections := o.hosts["h0"].ejections
+	for i := 0; i < ejections; i++ {
+		o.sweep(time.Now())
+	}
+	reportN(o, "h0", []bool{false})
+	if got := o.hosts["h0"].ejectedFor; got != 10*time.Second {
+		t.Fatalf("ejection time after recovery = %s, want the base 10s", got)
+	}
+}
+
+func TestOutlierSuccessRate(t *testing.T) {
+	spec := config.OutlierDetection{
+		Consecutive_errors:          1000,
+		Max_ejection_percent:        100,
+		Success_rate_minimum_hosts:  5,
+		Success_rate_request_volume: 10,
+	}
+	// fill 让h0的成功率为50%，其他服务器为100%
+	fill := func(o *outlierDetector, hosts int) {
+		for i := 0; i < 10; i++ {
+			reportN(o, "h0", []bool{i%2 == 0})
+			for j := 1; j < hosts; j++ {
+				reportN(o, fmt.Sprintf("h%d", j), []bool{true})
+			}
+		}
+	}
+
+	o, ejector := newTestOutlier(spec, 6)
+	fill(o, 6)
+	o.sweep(time.Now())
+	if !ejector["h0"] || ejector.count() != 1 {
+		t.Fatalf("ejected %v, want only h0", ejector)
+	}
+
+	// 达到请求量的服务器不足minimum_hosts时不按成功率移出
+	o, ejector = newTestOutlier(spec, 4)
+	fill(o, 4)
+	o.sweep(time.Now())
+	if ejector.count() != 0 {
+		t.Fatalf("ejected %v with fewer than minimum hosts", ejector)
+	}
+
+	// 请求量不足volume的服务器不参与统计
+	o, ejector = newTestOutlier(spec, 6)
+	reportN(o, "h0", []bool{false, false, false})
+	for j := 1; j < 6; j++ {
+		reportN(o, fmt.Sprintf("h%d", j), []bool{true, true, true})
+	}
+	o.sweep(time.Now())
+	if ejector.count() != 0 {
+		t.Fatalf("ejected %v below the request volume", ejector)
+	}
+}
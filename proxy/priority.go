@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"errors"
+	"fku-balancer/balancer"
+	"math/rand/v2"
+)
+
+// priorityLevel 是同一优先级的服务器,使用独立的负载均衡器
+type priorityLevel struct {
+	hosts  []string
+	lb     balancer.Balancer
+	picker balancer.Picker
+}
+
+// priorityBalancer 按优先级分配流量的负载均衡器
+// 只有location中配置了多个优先级(priority或backup)时才使用
+//
+// 流量分配与Envoy的priority levels相同:
+//  1. 每个优先级的健康度 health = min(1, 存活服务器数 / 服务器总数 * 超额配置系数)
+//  2. 从优先级0开始,每个优先级承担 min(health, 剩余流量),剩下的流量溢出到下一个优先级
+//  3. 所有优先级的健康度之和不足1时,按健康度的比例分配全部流量
+//
+// 服务器是否存活读取HttpProxy的alive,与健康检查的结果一致
+type priorityBalancer struct {
+	levels  []*priorityLevel
+	levelOf map[string]*priorityLevel
+	factor  float64
+	alive   func(string) bool
+}
+
+// newPriorityBalancer 创建按优先级分配流量的负载均衡器
+// levels 按优先级从高到低排列,每个优先级的负载均衡器已经创建好
+func newPriorityBalancer(levels []*priorityLevel, factor float64, alive func(string) bool) *priorityBalancer {
+	if factor <= 0 {
+		factor = balancer.DefaultOverprovisioningFactor
+	}
+	p := &priorityBalancer{
+		levels:  levels,
+		levelOf: make(map[string]*priorityLevel),
+		factor:  factor,
+		alive:   alive,
+	}
+	for _, level := range levels {
+		for _, host := range level.hosts {
+			p.levelOf[host] = level
+		}
+	}
+	return p
+}
+
+// loads 计算每个优先级承担的流量比例
+func (p *priorityBalancer) loads() []float64 {
+	health := make([]float64, len(p.levels))
+	total := 0.0
+	for i, level := range p.levels {
+		healthy := 0
+		for _, host := range level.hosts {
+			if p.alive(host) {
+				healthy++
+			}
+		}
+		health[i] = min(1, float64(healthy)/float64(len(level.hosts))*p.factor)
+		total += health[i]
+	}
+
+	loads := make([]float64, len(p.levels))
+	if total < 1 {
+		// 健康度之和不足1,按比例分配;全部不可用时total为0,所有优先级的比例都是0
+		for i := range loads {
+			if total > 0 {
+				loads[i] = health[i] / total
+			}
+		}
+		return loads
+	}
+
+	remaining := 1.0
+	for i := range loads {
+		loads[i] = min(health[i], remaining)
+		remaining -= loads[i]
+	}
+	return loads
+}
+
+// Pick 按流量比例随机选择一个优先级,由该优先级的负载均衡器选择服务器
+// 选中的优先级没有可用服务器,或者只能选到已尝试过的服务器(都达到并发上限或被熔断)时,依次尝试其他优先级
+// 所有优先级都只能选到已尝试过的服务器时,返回第一个选到的,与AsPicker的约定相同
+func (p *priorityBalancer) Pick(info *balancer.PickInfo) (string, error) {
+	loads := p.loads()
+
+	chosen := 0
+	r := rand.Float64()
+	for i, load := range loads {
+		if r < load {
+			chosen = i
+			break
+		}
+		r -= load
+	}
+
+	tried := ""
+	order := append([]*priorityLevel{p.levels[chosen]}, p.levels[:chosen]...)
+	order = append(order, p.levels[chosen+1:]...)
+	for _, level := range order {
+		host, err := level.picker.Pick(info)
+		if errors.Is(err, balancer.NoHostError) {
+			continue
+		}
+		if err == nil && info.Tried[host] {
+			if tried == "" {
+				tried = host
+			}
+			continue
+		}
+		return host, err
+	}
+	if tried != "" {
+		return tried, nil
+	}
+	return "", balancer.NoHostError
+}
+
+func (p *priorityBalancer) Balance(key string) (string, error) {
+	return p.Pick(&balancer.PickInfo{Key: key})
+}
+
+// 以下方法把服务器的变化和负载转发给服务器所在优先级的负载均衡器
+
+func (p *priorityBalancer) Add(host string) {
+	if level, ok := p.levelOf[host]; ok {
+		level.lb.Add(host)
+	}
+}
+
+func (p *priorityBalancer) Remove(host string) {
+	if level, ok := p.levelOf[host]; ok {
+		level.lb.Remove(host)
+	}
+}
+
+func (p *priorityBalancer) Inc(host string) {
+	if level, ok := p.levelOf[host]; ok {
+		level.lb.Inc(host)
+	}
+}
+
+func (p *priorityBalancer) Done(host string) {
+	if level, ok := p.levelOf[host]; ok {
+		level.lb.Done(host)
+	}
+}
+
+func (p *priorityBalancer) Report(host string, result balancer.Result) {
+	if level, ok := p.levelOf[host]; ok {
+		level.lb.Report(host, result)
+	}
+}
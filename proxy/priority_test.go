@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"fku-balancer/balancer"
+	"testing"
+)
+
+func newTestPriorityBalancer(levels ...[]string) *priorityBalancer {
+	built := make([]*priorityLevel, 0, len(levels))
+	for _, hosts := range levels {
+		lb := balancer.NewRoundRobin(append([]string(nil), hosts...))
+		built = append(built, &priorityLevel{hosts: hosts, lb: lb, picker: balancer.AsPicker(lb)})
+	}
+	return newPriorityBalancer(built, 0, func(string) bool { return true })
+}
+
+// TestPrioritySkipsTriedLevel 主服务器都已经尝试过时（如都达到了并发上限），请求交给备用服务器
+func TestPrioritySkipsTriedLevel(t *testing.T) {
+	p := newTestPriorityBalancer([]string{"p1", "p2"}, []string{"backup"})
+
+	for i := 0; i < 10; i++ {
+		host, err := p.Pick(&balancer.PickInfo{Tried: map[string]bool{"p1": true, "p2": true}})
+		if err != nil {
+			t.Fatalf("Pick: %v", err)
+		}
+		if host != "backup" {
+			t.Fatalf("Pick = %s, want backup", host)
+		}
+	}
+
+	tried := map[string]bool{"p1": true, "p2": true, "backup": true}
+	host, err := p.Pick(&balancer.PickInfo{Tried: tried})
+	if err != nil {
+		t.Fatalf("Pick: %v", err)
+	}
+	if !tried[host] {
+		t.Fatalf("Pick = %s, want one of the tried hosts when every level is exhausted", host)
+	}
+}
@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fku-balancer/config"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// maxProbeBody 是HTTP探测最多读取的响应体字节数
+const maxProbeBody = 64 << 10
+
+// prober 探测一个后端服务器是否存活
+type prober interface {
+	probe(target *url.URL) bool
+	// spec 返回探测方式的描述，配置相同的探测器描述相同，用于合并健康检查
+	spec() string
+}
+
+// newProber 根据location的健康检查配置创建探测器,spec为nil时使用TCP探测
+func newProber(spec *config.HealthCheck) (prober, error) {
+	if spec == nil {
+		return &tcpProber{timeout: ConnectionTimeout}, nil
+	}
+	timeout := ConnectionTimeout
+	if spec.Timeout > 0 {
+		timeout = time.Duration(spec.Timeout) * time.Second
+	}
+	if spec.ProbeType() == config.TcpProbe {
+		return &tcpProber{timeout: timeout}, nil
+	}
+
+	statuses, err := spec.StatusRanges()
+	if err != nil {
+		return nil, err
+	}
+	p := &httpProber{
+		method:   strings.ToUpper(spec.Method),
+		path:     spec.Path,
+		header:   make(http.Header),
+		statuses: statuses,
+		body:     spec.Body,
+		timeout:  timeout,
+		client: &http.Client{
+			// 后端服务器通常使用内部证书,健康检查只关心服务器是否可用
+			Transport: &http.Transport{
+				TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+				DisableKeepAlives: true,
+			},
+			// 重定向的状态码本身就是探测结果,不跟随重定向
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+	if p.method == "" {
+		p.method = http.MethodGet
+	}
+	if p.path == "" {
+		p.path = "/"
+	}
+	for k, v := range spec.Headers {
+		p.header.Set(k, v)
+	}
+	if spec.Body_regex != "" {
+		if p.bodyRegex, err = regexp.Compile(spec.Body_regex); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// tcpProber 建立TCP连接成功即认为服务器存活
+type tcpProber struct {
+	timeout time.Duration
+}
+
+func (p *tcpProber) probe(target *url.URL) bool {
+	return dialBackend(GetHost(target), p.timeout)
+}
+
+func (p *tcpProber) spec() string {
+	return fmt.Sprintf("tcp timeout=%s", p.timeout)
+}
+
+// httpProber 发送HTTP请求,检查状态码和响应体
+// proxy_pass是https时使用HTTPS
+type httpProber struct {
+	client    *http.Client
+	method    string
+	path      string
+	header    http.Header
+	statuses  []config.StatusRange
+	body      string
+	bodyRegex *regexp.Regexp
+	timeout   time.Duration
+}
+
+// spec 中的请求头按key排序输出，与配置中的顺序无关
+func (p *httpProber) spec() string {
+	regex := ""
+	if p.bodyRegex != nil {
+		regex = p.bodyRegex.String()
+	}
+	return fmt.Sprintf("http %s %s headers=%v status=%v body=%q regex=%q timeout=%s",
+		p.method, p.path, map[string][]string(p.header), p.statuses, p.body, regex, p.timeout)
+}
+
+func (p *httpProber) probe(target *url.URL) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	path, query, _ := strings.Cut(p.path, "?")
+	u := &url.URL{Scheme: target.Scheme, Host: target.Host, Path: path, RawQuery: query}
+	req, err := http.NewRequestWithContext(ctx, p.method, u.String(), nil)
+	if err != nil {
+		return false
+	}
+	req.Header = p.header.Clone()
+	if host := p.header.Get("Host"); host != "" {
+		req.Host = host
+	}
+	req.Header.Set(XProxy, ReverseProxy)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	healthy := false
+	for _, s := range p.statuses {
+		if s.Contains(resp.StatusCode) {
+			healthy = true
+			break
+		}
+	}
+	if !healthy {
+		return false
+	}
+	if p.body == "" && p.bodyRegex == nil {
+		return true
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxProbeBody))
+	if err != nil {
+		return false
+	}
+	if p.body != "" && !strings.Contains(string(body), p.body) {
+		return false
+	}
+	return p.bodyRegex == nil || p.bodyRegex.Match(body)
+}
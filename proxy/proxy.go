@@ -1,12 +1,16 @@
 package proxy
 
 import (
+	"errors"
 	"fku-balancer/balancer"
+	"fku-balancer/config"
 	"fmt"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"sort"
 	"sync"
+	"time"
 )
 
 // Http反向代理
@@ -22,18 +26,38 @@ var (
 type HttpProxy struct {
 	hostMap map[string]*httputil.ReverseProxy
 	lb      balancer.Balancer
+	picker  balancer.Picker
 	alive   map[string]bool
+	// ejected 被异常检测暂时移出的服务器
+	ejected map[string]bool
+	hashKey hashKeyFunc
+	// limits 每个服务器的自适应并发上限,为nil表示没有开启
+	limits map[string]*vegasLimit
+	// targets 每个服务器的地址，健康检查根据它的scheme选择HTTP或HTTPS
+	targets map[string]*url.URL
+	// prober 健康检查使用的探测方式，policy 探测的频率和状态切换的条件
+	prober prober
+	policy *checkPolicy
+	// outlier 被动异常检测，为nil表示没有开启
+	outlier *outlierDetector
+	// breakers 每个服务器的熔断器，为nil表示没有开启；broken 熔断中的服务器
+	breakers         map[string]*circuitBreaker
+	broken           map[string]bool
+	breakerListeners []func(BreakerTransition)
 	sync.RWMutex
 }
 
-// 把多个后端服务器地址转换成一个统一的HTTP代理，支持负载均衡和健康检查
-func NewHttpProxy(targetHosts []string, algorithm string) (*HttpProxy, error) {
-	hosts := make([]string, 0)
+// 把location中的多个后端服务器转换成一个统一的HTTP代理，支持负载均衡和健康检查
+// cfg 提供各个location共用的全局配置，如负载均衡器所在的区域
+func NewHttpProxy(cfg *config.Config, location *config.Location) (*HttpProxy, error) {
+	// 按优先级分组，levels的key是优先级
+	levels := make(map[int][]*balancer.Backend)
 	hostsMap := make(map[string]*httputil.ReverseProxy)
 	aliveMap := make(map[string]bool)
+	targets := make(map[string]*url.URL)
 
-	for _, targetHost := range targetHosts {
-		url, err := url.Parse(targetHost)
+	for _, pass := range location.Proxy_pass {
+		url, err := url.Parse(pass.Url)
 		if err != nil {
 			return nil, err
 		}
@@ -46,34 +70,147 @@ func NewHttpProxy(targetHosts []string, algorithm string) (*HttpProxy, error) {
 			req.Header.Set(XProxy, ReverseProxy)
 			req.Header.Set(XRealIP, GetIP(req))
 		}
+		hostProxy.ErrorHandler = proxyErrorHandler
 
 		host := GetHost(url)
-		hosts = append(hosts, host)
+		levels[pass.Priority] = append(levels[pass.Priority], &balancer.Backend{
+			Host:   host,
+			Weight: pass.Weight,
+			Labels: pass.Labels,
+		})
 		hostsMap[host] = hostProxy
 		aliveMap[host] = true
+		targets[host] = url
 	}
 
-	lb, err := balancer.Build(algorithm, hosts)
+	prober, err := newProber(location.Health_check)
+	if err != nil {
+		return nil, err
+	}
 
+	hashKey, err := parseHashKey(location.Hash_key)
 	if err != nil {
 		return nil, err
 	}
 
-	return &HttpProxy{
+	hash, err := balancer.GetHashFunc(location.Hash_function)
+	if err != nil {
+		return nil, err
+	}
+	opts := &balancer.Options{
+		Replicas:   location.Hash_replicas,
+		Hash:       hash,
+		LoadFactor: location.Load_factor,
+		TableSize:  location.Table_size,
+
+		SlowStartWindow:     time.Duration(location.Slow_start) * time.Second,
+		SlowStartAggression: location.Slow_start_aggression,
+
+		Zone:                   cfg.Zone,
+		OverprovisioningFactor: location.Overprovisioning_factor,
+
+		InstanceID:    int(cfg.Instance_id),
+		InstanceCount: int(cfg.Instance_count),
+		SubsetSize:    location.Subset_size,
+	}
+
+	h := &HttpProxy{
 		hostMap: hostsMap,
-		lb:      lb,
 		alive:   aliveMap,
-	}, nil
+		ejected: make(map[string]bool),
+		broken:  make(map[string]bool),
+		hashKey: hashKey,
+		targets: targets,
+		prober:  prober,
+		policy:  newCheckPolicy(cfg, location.Health_check),
+	}
+
+	// 只有一个优先级时直接使用该算法的负载均衡器
+	// 有多个优先级时，每个优先级各自创建负载均衡器，再由priorityBalancer按健康度分配流量
+	if len(levels) <= 1 {
+		var backends []*balancer.Backend
+		for _, level := range levels {
+			backends = level
+		}
+		h.lb, err = balancer.Build(location.Balance_mode, backends, opts)
+	} else {
+		h.lb, err = buildPriorityBalancer(location, levels, opts, h.available)
+	}
+	if err != nil {
+		return nil, err
+	}
+	h.picker = balancer.AsPicker(h.lb)
+
+	if location.Adaptive_concurrency {
+		h.limits = make(map[string]*vegasLimit, len(hostsMap))
+		for host := range hostsMap {
+			h.limits[host] = newVegasLimit(location.Initial_concurrency, location.Max_concurrency)
+		}
+	}
+
+	if location.Circuit_breaker != nil {
+		policy := newBreakerPolicy(location.Circuit_breaker)
+		h.breakers = make(map[string]*circuitBreaker, len(hostsMap))
+		for host := range hostsMap {
+			h.breakers[host] = newCircuitBreaker(host, policy, h.breakerTransition)
+		}
+	}
+
+	if location.Outlier_detection != nil {
+		hosts := make([]string, 0, len(hostsMap))
+		for host := range hostsMap {
+			hosts = append(hosts, host)
+		}
+		h.outlier = newOutlierDetector(location.Outlier_detection, hosts, h.setEjected)
+		go h.outlier.run()
+	}
+
+	return h, nil
+}
+
+// buildPriorityBalancer 为每个优先级创建负载均衡器，按优先级从高到低组合起来
+func buildPriorityBalancer(location *config.Location, levels map[int][]*balancer.Backend,
+	opts *balancer.Options, alive func(string) bool) (*priorityBalancer, error) {
+	priorities := make([]int, 0, len(levels))
+	for priority := range levels {
+		priorities = append(priorities, priority)
+	}
+	sort.Ints(priorities)
+
+	built := make([]*priorityLevel, 0, len(priorities))
+	for _, priority := range priorities {
+		backends := levels[priority]
+		lb, err := balancer.Build(location.Balance_mode, backends, opts)
+		if err != nil {
+			return nil, err
+		}
+		level := &priorityLevel{lb: lb, picker: balancer.AsPicker(lb)}
+		for _, b := range backends {
+			level.hosts = append(level.hosts, b.Host)
+		}
+		built = append(built, level)
+	}
+	return newPriorityBalancer(built, location.Overprovisioning_factor, alive), nil
 }
 
 // ServeHTTP 实现http.Handler接口，处理HTTP请求
 // 这是反向代理的核心方法，负责接收请求并转发
 func (h *HttpProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
-	host, err := h.lb.Balance(GetIP(r))
+	host, t, err := h.pick(&balancer.PickInfo{
+		Ctx:     r.Context(),
+		Request: r,
+		Key:     h.hashKey(r),
+	})
 
 	fmt.Println("拿到的host: ", host)
 
+	if errors.Is(err, errConcurrencyLimited) || errors.Is(err, errCircuitOpen) {
+		// 所有服务器都达到了并发上限或者处于熔断中，直接拒绝请求
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(fmt.Sprintf("balance error: %s", err.Error())))
+		return
+	}
 	if err != nil {
 		// 选择失败（如没有可用服务器）
 		// 返回502错误
@@ -84,7 +221,107 @@ func (h *HttpProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	h.lb.Inc(host)
 
-	defer h.lb.Done(host)
+	// 记录状态码、耗时和转发错误,请求结束后报告给负载均衡器
+	result := &balancer.Result{}
+	recorder := &statusRecorder{ResponseWriter: w}
+	start := time.Now()
+
+	// 复制响应体失败时(后端中途断开、客户端断开)ReverseProxy以http.ErrAbortHandler panic,
+	// 这时同样要报告结果并释放并发位置和探测位置,否则每次中断都会永久占用一个位置
+	defer func() {
+		aborted := recover()
+		if aborted != nil && result.Err == nil {
+			result.Err = fmt.Errorf("proxy aborted: %v", aborted)
+		}
+		result.Duration = time.Since(start)
+		result.StatusCode = recorder.status
+		h.lb.Report(host, *result)
+		if h.outlier != nil {
+			h.outlier.report(host, *result)
+		}
+		t.finish(*result)
+		h.lb.Done(host)
+		if aborted != nil {
+			panic(aborted)
+		}
+	}()
+
+	h.hostMap[host].ServeHTTP(recorder, withResult(r, result))
+}
+
+var (
+	// errConcurrencyLimited 表示所有可选的服务器都达到了并发上限
+	errConcurrencyLimited = errors.New("all backends reached the concurrency limit")
+	// errCircuitOpen 表示所有可选的服务器的熔断器都不放行请求
+	errCircuitOpen = errors.New("all backends are rejected by circuit breakers")
+)
+
+// ticket 记录一个请求占用的并发位置和熔断器的探测位置，请求结束后调用finish释放
+// generation 是放行探测请求的半开状态的轮次
+type ticket struct {
+	limit      *vegasLimit
+	breaker    *circuitBreaker
+	probe      bool
+	generation uint64
+}
+
+// finish 把请求结果交给并发限制和熔断器
+func (t *ticket) finish(result balancer.Result) {
+	if t.limit != nil {
+		t.limit.release(result.Duration, result.Failed())
+	}
+	if t.breaker != nil {
+		t.breaker.record(result, t.probe, t.generation)
+	}
+}
+
+// admit 判断选中的服务器能否接收这个请求
+// 熔断器不放行或者达到并发上限时返回对应的错误
+func (h *HttpProxy) admit(host string) (*ticket, error) {
+	t := &ticket{}
+	if breaker := h.breakers[host]; breaker != nil {
+		ok, probe, generation := breaker.allow()
+		if !ok {
+			return nil, errCircuitOpen
+		}
+		t.breaker, t.probe, t.generation = breaker, probe, generation
+	}
+	if limit := h.limits[host]; limit != nil {
+		if !limit.acquire() {
+			if t.probe {
+				t.breaker.cancel(t.generation)
+			}
+			return nil, errConcurrencyLimited
+		}
+		t.limit = limit
+	}
+	return t, nil
+}
 
-	h.hostMap[host].ServeHTTP(w, r)
+// pick 选择一个服务器，并占用它的并发位置和熔断器的探测位置
+// 选中的服务器不能接收请求时，把它记为已尝试，重新选择其他服务器
+// 返回的ticket在请求结束后必须调用finish
+func (h *HttpProxy) pick(info *balancer.PickInfo) (string, *ticket, error) {
+	for {
+		host, err := h.picker.Pick(info)
+		if err != nil {
+			return "", nil, err
+		}
+		t, err := h.admit(host)
+		if err == nil {
+			return host, t, nil
+		}
+
+		// Picker只能选到已尝试过的服务器时会返回它，说明没有其他服务器可选
+		if info.Tried[host] {
+			return "", nil, err
+		}
+		if info.Tried == nil {
+			info.Tried = make(map[string]bool)
+		}
+		info.Tried[host] = true
+		if len(info.Tried) >= len(h.hostMap) {
+			return "", nil, err
+		}
+	}
 }
@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"context"
+	"fku-balancer/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestProxy 创建转发到给定后端的HttpProxy
+func newTestProxy(t *testing.T, location *config.Location, backends ...string) *HttpProxy {
+	t.Helper()
+	if location.Balance_mode == "" {
+		location.Balance_mode = "round-robin"
+	}
+	for _, backend := range backends {
+		location.Proxy_pass = append(location.Proxy_pass, &config.ProxyPass{Url: backend, Weight: 1})
+	}
+	h, err := NewHttpProxy(&config.Config{Health_check_interval: 1}, location)
+	if err != nil {
+		t.Fatalf("NewHttpProxy: %v", err)
+	}
+	return h
+}
+
+// serveAborted 转发一个请求，并吞掉ReverseProxy中断响应时的panic
+// 请求上下文中没有http.Server时ReverseProxy不会panic，所以这里放入一个
+func serveAborted(h http.Handler, r *http.Request) (aborted any) {
+	defer func() { aborted = recover() }()
+	r = r.WithContext(context.WithValue(r.Context(), http.ServerContextKey, &http.Server{}))
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	return nil
+}
+
+// TestServeHTTPAbortReleasesTicket 后端在响应体中途断开时，ReverseProxy会panic，
+// 并发位置仍然要被释放
+func TestServeHTTPAbortReleasesTicket(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 声明的长度比实际写出的长，然后断开连接，让代理复制响应体时出错
+		w.Header().Set("Content-Length", "1000")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("partial"))
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err == nil {
+			_ = conn.Close()
+		}
+	}))
+	defer backend.Close()
+
+	h := newTestProxy(t, &config.Location{
+		Pattern:              "/",
+		Adaptive_concurrency: true,
+		Initial_concurrency:  2,
+		Max_concurrency:      2,
+	}, backend.URL)
+
+	for i := 0; i < 5; i++ {
+		if aborted := serveAborted(h, httptest.NewRequest(http.MethodGet, "/", nil)); aborted != http.ErrAbortHandler {
+			t.Fatalf("request %d: recovered %v, want http.ErrAbortHandler", i, aborted)
+		}
+	}
+	for host, limit := range h.ConcurrencyLimits() {
+		if limit.Inflight != 0 {
+			t.Errorf("%s: %d requests still in flight after aborted responses", host, limit.Inflight)
+		}
+	}
+}
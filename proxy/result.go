@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"context"
+	"fku-balancer/balancer"
+	"net/http"
+)
+
+// resultKey 是请求上下文中保存 *balancer.Result 的key
+type resultKey struct{}
+
+// withResult 在请求上下文中放入一个请求结果,供ErrorHandler记录转发错误
+func withResult(r *http.Request, result *balancer.Result) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), resultKey{}, result))
+}
+
+// resultFrom 从请求上下文中取出请求结果,没有时返回nil
+func resultFrom(r *http.Request) *balancer.Result {
+	result, _ := r.Context().Value(resultKey{}).(*balancer.Result)
+	return result
+}
+
+// proxyErrorHandler 是每个后端ReverseProxy的ErrorHandler
+// 和httputil默认的行为一样返回502,同时把错误记录到请求结果中
+func proxyErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	if result := resultFrom(r); result != nil {
+		result.Err = err
+	}
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+// statusRecorder 记录写给客户端的状态码
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// Unwrap 让http.ResponseController可以拿到原始的ResponseWriter
+// ReverseProxy通过它来Flush流式响应
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
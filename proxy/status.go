@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// maxBreakerTransitions 是每个location保留的最近熔断器状态变化数
+const maxBreakerTransitions = 32
+
+// Status 是代理运行状态的查询接口，以JSON格式返回每个location的状态，用于监控和排查问题
+type Status struct {
+	sync.Mutex
+
+	locations []*locationStatus
+}
+
+// locationStatus 是一个location的HttpProxy，以及它最近的熔断器状态变化
+type locationStatus struct {
+	pattern     string
+	proxy       *HttpProxy
+	transitions []BreakerTransition
+}
+
+// LocationStatus 是状态接口返回的一个location的状态
+type LocationStatus struct {
+	Pattern string `json:"pattern"`
+	// ConcurrencyLimits 每个服务器的并发上限和正在处理的请求数，没有开启自适应并发限制时为空
+	ConcurrencyLimits map[string]ConcurrencyLimit `json:"concurrency_limits,omitempty"`
+	// CircuitBreakers 每个服务器熔断器的状态，BreakerTransitions 最近的状态变化，按时间顺序排列
+	// 没有开启熔断器时为空
+	CircuitBreakers    map[string]BreakerState `json:"circuit_breakers,omitempty"`
+	BreakerTransitions []BreakerTransition     `json:"breaker_transitions,omitempty"`
+}
+
+// NewStatus 创建状态查询接口
+func NewStatus() *Status {
+	return &Status{}
+}
+
+// Add 添加一个location的HttpProxy，并订阅它的熔断器状态变化
+func (s *Status) Add(pattern string, h *HttpProxy) {
+	l := &locationStatus{pattern: pattern, proxy: h}
+	s.Lock()
+	s.locations = append(s.locations, l)
+	s.Unlock()
+
+	h.OnBreakerTransition(func(t BreakerTransition) {
+		s.Lock()
+		defer s.Unlock()
+
+		l.transitions = append(l.transitions, t)
+		if len(l.transitions) > maxBreakerTransitions {
+			l.transitions = l.transitions[len(l.transitions)-maxBreakerTransitions:]
+		}
+	})
+}
+
+// Locations 返回所有location当前的状态，按添加的顺序排列
+func (s *Status) Locations() []LocationStatus {
+	s.Lock()
+	defer s.Unlock()
+
+	locations := make([]LocationStatus, 0, len(s.locations))
+	for _, l := range s.locations {
+		locations = append(locations, LocationStatus{
+			Pattern:            l.pattern,
+			ConcurrencyLimits:  l.proxy.ConcurrencyLimits(),
+			CircuitBreakers:    l.proxy.BreakerStates(),
+			BreakerTransitions: append([]BreakerTransition(nil), l.transitions...),
+		})
+	}
+	return locations
+}
+
+func (s *Status) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(struct {
+		Locations []LocationStatus `json:"locations"`
+	}{s.Locations()})
+}
@@ -0,0 +1,93 @@
+package simulate
+
+import (
+	"fku-balancer/balancer"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Main 是 simulate 子命令的入口，args 是子命令之后的参数
+//
+//	fku-balancer simulate -algorithm p2c -hosts 10 -requests 100000 -capacity 20
+//	fku-balancer simulate -algorithm maglev,rendezvous -churn-every 10000 -zipf 1.2
+//	fku-balancer simulate -algorithm all -workload keys.txt
+//
+// -algorithm 可以用逗号分隔多个算法，all 表示所有已注册的算法，每个算法使用相同的请求和随机种子
+func Main(args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	algorithms := fs.String("algorithm", balancer.RandomBalancer,
+		"balance algorithms separated by commas, or all: "+strings.Join(balancer.Algorithms(), ", "))
+	hashFunction := fs.String("hash-function", "", "hash function of hash based algorithms (crc32, fnv64a)")
+	replicas := fs.Int("hash-replicas", 0, "virtual nodes per host of consistent hash algorithms, 0 for default")
+	loadFactor := fs.Float64("load-factor", 0, "load factor of the bounded algorithm, 0 for default")
+	tableSize := fs.Int("table-size", 0, "lookup table size of the maglev algorithm, 0 for default")
+
+	cfg := &Config{}
+	weights := fs.String("weights", "", "host weights separated by commas, missing weights are 1")
+	fs.IntVar(&cfg.Hosts, "hosts", 10, "number of backend hosts")
+	fs.IntVar(&cfg.Requests, "requests", 100000, "number of synthetic requests")
+	fs.IntVar(&cfg.Keys, "keys", 10000, "number of distinct synthetic keys")
+	fs.Float64Var(&cfg.Zipf, "zipf", 0, "zipf exponent of synthetic keys, must be greater than 1, 0 for uniform keys")
+	fs.StringVar(&cfg.Workload, "workload", "", "recorded workload file with one key per line, replaces synthetic requests")
+	fs.Float64Var(&cfg.Rate, "rate", 1000, "requests per second")
+	fs.DurationVar(&cfg.Latency, "latency", 10*time.Millisecond, "mean latency of an idle host")
+	fs.IntVar(&cfg.Capacity, "capacity", 0, "in-flight requests that double a host's latency, 0 for no queueing")
+	fs.IntVar(&cfg.SlowHosts, "slow-hosts", 0, "number of slow hosts")
+	fs.Float64Var(&cfg.SlowFactor, "slow-factor", 5, "latency multiplier of slow hosts")
+	fs.Float64Var(&cfg.FailureRate, "failure-rate", 0, "probability that a request fails")
+	fs.IntVar(&cfg.ChurnEvery, "churn-every", 0, "remove or re-add a host every n requests, 0 for no churn")
+	fs.Int64Var(&cfg.Seed, "seed", 1, "random seed")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	hash, err := balancer.GetHashFunc(*hashFunction)
+	if err != nil {
+		return err
+	}
+	for _, s := range strings.Split(*weights, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		weight, err := strconv.Atoi(s)
+		if err != nil || weight < 1 {
+			return fmt.Errorf("invalid weight \"%s\"", s)
+		}
+		cfg.Weights = append(cfg.Weights, weight)
+	}
+
+	names := strings.Split(*algorithms, ",")
+	if *algorithms == "all" {
+		names = balancer.Algorithms()
+	}
+	for i, name := range names {
+		name = strings.TrimSpace(name)
+		if !balancer.IsRegistered(name) {
+			return fmt.Errorf("the balance algorithm \"%s\" not supported, available: %s",
+				name, strings.Join(balancer.Algorithms(), ", "))
+		}
+
+		// 每个算法使用独立的Options，避免算法修改参数影响下一个算法
+		run := *cfg
+		run.Algorithm = name
+		run.Options = &balancer.Options{
+			Replicas:   *replicas,
+			Hash:       hash,
+			LoadFactor: *loadFactor,
+			TableSize:  *tableSize,
+		}
+		report, err := Run(&run)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		report.Print(os.Stdout)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package simulate
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"text/tabwriter"
+	"time"
+)
+
+// HostStats 是一个服务器的模拟结果
+type HostStats struct {
+	Host     string
+	Weight   int
+	Requests int
+	Failures int
+	P50      time.Duration
+	P99      time.Duration
+}
+
+// ChurnEvent 是一次服务器变化对key映射的影响
+type ChurnEvent struct {
+	Host  string
+	Added bool
+	// Keys 统计使用的key数，Moved 映射发生变化的key数；算法不使用key时不统计，Keys为0
+	Keys  int
+	Moved int
+	// Necessary 必须移动的key数，即原来在被移除服务器上、或加回后分配到该服务器上的key数
+	Necessary int
+}
+
+// Report 是一次模拟的结果
+type Report struct {
+	Algorithm string
+	// Duration 模拟的虚拟时长
+	Duration time.Duration
+	// Errors 负载均衡器没有选出服务器的请求数
+	Errors int
+
+	Hosts []HostStats
+	Churn []ChurnEvent
+
+	// P50、P90、P99、P999 所有请求的延迟分位数
+	P50  time.Duration
+	P90  time.Duration
+	P99  time.Duration
+	P999 time.Duration
+
+	// Fairness 按权重归一化后各服务器请求数的Jain公平指数，1表示完全均匀，1/n表示全部集中在一个服务器
+	Fairness float64
+	// MaxMeanRatio 按权重归一化后请求数最多的服务器与平均值之比
+	MaxMeanRatio float64
+}
+
+// collect 汇总每个服务器和所有请求的统计数据
+func (r *Report) collect(hosts []*host) {
+	var all []time.Duration
+	var sum, sumSquares, maxLoad float64
+	for _, h := range hosts {
+		slices.Sort(h.latencies)
+		all = append(all, h.latencies...)
+		r.Hosts = append(r.Hosts, HostStats{
+			Host:     h.name,
+			Weight:   h.weight,
+			Requests: h.requests,
+			Failures: h.failures,
+			P50:      percentile(h.latencies, 0.5),
+			P99:      percentile(h.latencies, 0.99),
+		})
+
+		load := float64(h.requests) / float64(max(1, h.weight))
+		sum += load
+		sumSquares += load * load
+		maxLoad = math.Max(maxLoad, load)
+	}
+
+	slices.Sort(all)
+	r.P50 = percentile(all, 0.5)
+	r.P90 = percentile(all, 0.9)
+	r.P99 = percentile(all, 0.99)
+	r.P999 = percentile(all, 0.999)
+
+	if sumSquares > 0 {
+		n := float64(len(hosts))
+		r.Fairness = sum * sum / (n * sumSquares)
+		r.MaxMeanRatio = maxLoad / (sum / n)
+	}
+}
+
+// Print 以表格形式输出模拟结果
+func (r *Report) Print(out io.Writer) {
+	total := 0
+	for _, h := range r.Hosts {
+		total += h.Requests
+	}
+
+	fmt.Fprintf(out, "algorithm: %s, requests: %d, errors: %d, simulated time: %s\n",
+		r.Algorithm, total, r.Errors, r.Duration.Round(time.Millisecond))
+	fmt.Fprintf(out, "latency p50: %s, p90: %s, p99: %s, p999: %s\n",
+		r.P50.Round(time.Microsecond), r.P90.Round(time.Microsecond),
+		r.P99.Round(time.Microsecond), r.P999.Round(time.Microsecond))
+	fmt.Fprintf(out, "fairness (jain): %.4f, max/mean: %.3f\n\n", r.Fairness, r.MaxMeanRatio)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "host\tweight\trequests\tshare\tfailures\tp50\tp99")
+	for _, h := range r.Hosts {
+		share := 0.0
+		if total > 0 {
+			share = float64(h.Requests) / float64(total) * 100
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.2f%%\t%d\t%s\t%s\n", h.Host, h.Weight, h.Requests, share,
+			h.Failures, h.P50.Round(time.Microsecond), h.P99.Round(time.Microsecond))
+	}
+	w.Flush()
+
+	if len(r.Churn) == 0 {
+		return
+	}
+	fmt.Fprintln(out)
+	w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "churn\thost\tkeys\tmoved\tnecessary")
+	moved, necessary, keys := 0, 0, 0
+	for _, e := range r.Churn {
+		action := "remove"
+		if e.Added {
+			action = "add"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", action, e.Host, e.Keys,
+			percent(e.Moved, e.Keys), percent(e.Necessary, e.Keys))
+		moved += e.Moved
+		necessary += e.Necessary
+		keys += e.Keys
+	}
+	fmt.Fprintf(w, "total\t\t%d\t%s\t%s\n", keys, percent(moved, keys), percent(necessary, keys))
+	w.Flush()
+}
+
+// percent 返回n占total的百分比，total为0（没有统计）时返回"-"
+func percent(n, total int) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f%%", float64(n)/float64(total)*100)
+}
@@ -0,0 +1,371 @@
+// Package simulate 离线模拟负载均衡算法
+// 用合成的或者录制的请求回放给任意已注册的负载均衡算法，模拟后端延迟、失败和服务器上下线，
+// 不发送任何网络请求，用于在上线前比较不同算法的负载分布、尾延迟和服务器变化时key的迁移
+package simulate
+
+import (
+	"bufio"
+	"container/heap"
+	"errors"
+	"fku-balancer/balancer"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+)
+
+// errSimulated 是模拟的后端失败
+var errSimulated = errors.New("simulated backend failure")
+
+// Config 是一次模拟的参数
+type Config struct {
+	// Algorithm 负载均衡算法，必须已经注册
+	Algorithm string
+	// Options 传给负载均衡算法的参数
+	Options *balancer.Options
+
+	// Hosts 后端服务器数
+	Hosts int
+	// Weights 每个服务器的权重，为空表示都是1，长度不足时剩下的服务器权重为1
+	Weights []int
+
+	// Requests 合成请求数，配置了Workload时使用Workload中的全部请求
+	Requests int
+	// Keys 合成请求使用的不同key的数量
+	Keys int
+	// Zipf 大于1时key按Zipf分布生成，值越大热点越集中，否则均匀分布
+	Zipf float64
+	// Workload 录制的请求文件，每行一个key，空行被忽略
+	Workload string
+	// Rate 每秒到达的请求数，请求间隔服从指数分布
+	Rate float64
+
+	// Latency 服务器无负载时的平均延迟
+	Latency time.Duration
+	// Capacity 服务器的并发能力，每多 Capacity 个正在处理的请求延迟增加一倍
+	Capacity int
+	// SlowHosts 前 SlowHosts 个服务器的延迟乘以 SlowFactor
+	SlowHosts  int
+	SlowFactor float64
+	// FailureRate 每个请求失败的概率
+	FailureRate float64
+
+	// ChurnEvery 每隔多少个请求发生一次服务器变化，0表示不发生变化
+	// 变化交替进行：随机移除一个服务器，下一次再把它加回来
+	ChurnEvery int
+
+	// Seed 随机数种子，相同的种子得到相同的结果
+	Seed int64
+}
+
+// host 是一个模拟的后端服务器
+type host struct {
+	name     string
+	weight   int
+	latency  time.Duration
+	inflight int
+	alive    bool
+
+	requests  int
+	failures  int
+	latencies []time.Duration
+}
+
+// completion 是一个请求结束的事件
+type completion struct {
+	at     time.Duration
+	host   *host
+	result balancer.Result
+}
+
+// completions 按结束时间排序的最小堆
+type completions []*completion
+
+func (c completions) Len() int           { return len(c) }
+func (c completions) Less(i, j int) bool { return c[i].at < c[j].at }
+func (c completions) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c *completions) Push(x any)        { *c = append(*c, x.(*completion)) }
+func (c *completions) Pop() any {
+	old := *c
+	n := len(old)
+	x := old[n-1]
+	*c = old[:n-1]
+	return x
+}
+
+// Run 执行一次模拟
+//
+// 模拟使用虚拟时间：请求按Rate到达，每个请求由负载均衡器选择服务器，调用Inc，
+// 到达结束时间时调用Done和Report。服务器的延迟随正在处理的请求数增加，
+// 因此按负载选择的算法（如least-load、p2c、peak-ewma）能体现出与其他算法的差异
+func Run(cfg *Config) (*Report, error) {
+	if cfg.Hosts <= 0 {
+		return nil, errors.New("hosts must be greater than 0")
+	}
+	if cfg.Rate <= 0 {
+		return nil, errors.New("rate must be greater than 0")
+	}
+	keys, err := workload(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := make([]*host, cfg.Hosts)
+	byName := make(map[string]*host, cfg.Hosts)
+	backends := make([]*balancer.Backend, cfg.Hosts)
+	for i := range hosts {
+		weight := 1
+		if i < len(cfg.Weights) {
+			weight = cfg.Weights[i]
+		}
+		latency := cfg.Latency
+		if i < cfg.SlowHosts && cfg.SlowFactor > 0 {
+			latency = time.Duration(float64(latency) * cfg.SlowFactor)
+		}
+		hosts[i] = &host{
+			name:    fmt.Sprintf("10.0.%d.%d:80", i/256, i%256),
+			weight:  weight,
+			latency: latency,
+			alive:   true,
+		}
+		byName[hosts[i].name] = hosts[i]
+		backends[i] = &balancer.Backend{Host: hosts[i].name, Weight: weight}
+	}
+
+	// 负载均衡算法使用同一个种子，保证相同的种子得到相同的结果
+	opts := balancer.Options{}
+	if cfg.Options != nil {
+		opts = *cfg.Options
+	}
+	if opts.Seed == 0 {
+		opts.Seed = cfg.Seed
+	}
+	lb, err := balancer.Build(cfg.Algorithm, backends, &opts)
+	if err != nil {
+		return nil, err
+	}
+	picker := balancer.AsPicker(lb)
+
+	// 统计key迁移时调用Balance会推进轮询计数、消耗随机数，改变被测负载均衡器的行为，
+	// 所以在另一个服务器变化相同的负载均衡器上统计；不使用key的算法不统计
+	var mirror balancer.Balancer
+	if cfg.ChurnEvery > 0 && keyedAlgorithms[cfg.Algorithm] {
+		mirrorOpts := opts
+		if mirror, err = balancer.Build(cfg.Algorithm, backends, &mirrorOpts); err != nil {
+			return nil, err
+		}
+	}
+
+	rnd := rand.New(rand.NewSource(cfg.Seed))
+	report := &Report{Algorithm: cfg.Algorithm}
+	pending := &completions{}
+	var now time.Duration
+	var removed *host
+
+	for i, key := range keys {
+		now += time.Duration(rnd.ExpFloat64() / cfg.Rate * float64(time.Second))
+		for pending.Len() > 0 && (*pending)[0].at <= now {
+			c := heap.Pop(pending).(*completion)
+			c.host.inflight--
+			// 与代理相同，先报告结果再Done，服务器已经被移除时最后一个样本也不会丢失
+			lb.Report(c.host.name, c.result)
+			lb.Done(c.host.name)
+		}
+
+		if cfg.ChurnEvery > 0 && i > 0 && i%cfg.ChurnEvery == 0 {
+			removed = churn(lb, mirror, hosts, removed, keys, rnd, report)
+		}
+
+		name, err := picker.Pick(&balancer.PickInfo{Key: key})
+		if err != nil {
+			report.Errors++
+			continue
+		}
+		h, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("algorithm %s picked unknown host %s", cfg.Algorithm, name)
+		}
+
+		lb.Inc(h.name)
+		h.inflight++
+
+		// 延迟 = 基础延迟 * 负载系数 * 随机波动，随机波动服从均值为1的指数分布，模拟长尾
+		load := 1.0
+		if cfg.Capacity > 0 {
+			load += float64(h.inflight-1) / float64(cfg.Capacity)
+		}
+		latency := time.Duration(float64(h.latency) * load * rnd.ExpFloat64())
+		result := balancer.Result{Duration: latency, StatusCode: 200}
+		if rnd.Float64() < cfg.FailureRate {
+			result.Err = errSimulated
+			result.StatusCode = 502
+			h.failures++
+		}
+		h.requests++
+		h.latencies = append(h.latencies, latency)
+		heap.Push(pending, &completion{at: now + latency, host: h, result: result})
+	}
+
+	report.Duration = now
+	report.collect(hosts)
+	return report, nil
+}
+
+// keyedAlgorithms 是按key选择服务器的内置算法，只有它们统计服务器变化时的key迁移
+var keyedAlgorithms = map[string]bool{
+	balancer.IPHashBalancer:         true,
+	balancer.ConsistentHashBalancer: true,
+	balancer.BoundedBalancer:        true,
+	balancer.MaglevBalancer:         true,
+	balancer.RendezvousBalancer:     true,
+	balancer.JumpHashBalancer:       true,
+}
+
+// churn 发生一次服务器变化：上一次移除了服务器时把它加回来，否则随机移除一个服务器
+// mirror 不为nil时，变化前后用同一批key调用mirror的Balance，记录映射发生变化的key的比例
+func churn(lb, mirror balancer.Balancer, hosts []*host, removed *host, keys []string, rnd *rand.Rand, report *Report) *host {
+	var sample []string
+	var before map[string]string
+	if mirror != nil {
+		sample = sampleKeys(keys)
+		before = mapping(mirror, sample)
+	}
+
+	event := ChurnEvent{}
+	if removed != nil {
+		removed.alive = true
+		lb.Add(removed.name)
+		if mirror != nil {
+			mirror.Add(removed.name)
+		}
+		event.Host, event.Added = removed.name, true
+		removed = nil
+	} else {
+		var alive []*host
+		for _, h := range hosts {
+			if h.alive {
+				alive = append(alive, h)
+			}
+		}
+		if len(alive) <= 1 {
+			return nil
+		}
+		removed = alive[rnd.Intn(len(alive))]
+		removed.alive = false
+		lb.Remove(removed.name)
+		if mirror != nil {
+			mirror.Remove(removed.name)
+		}
+		event.Host = removed.name
+	}
+	if mirror == nil {
+		report.Churn = append(report.Churn, event)
+		return removed
+	}
+
+	after := mapping(mirror, sample)
+	for _, key := range sample {
+		if before[key] != after[key] {
+			event.Moved++
+		}
+		// 只有原来在被移除服务器上的key（或者加回后移到该服务器上的key）必须移动
+		if before[key] == event.Host || after[key] == event.Host {
+			event.Necessary++
+		}
+	}
+	event.Keys = len(sample)
+	report.Churn = append(report.Churn, event)
+	return removed
+}
+
+// maxSampleKeys 是统计key迁移时最多使用的key数
+const maxSampleKeys = 1000
+
+// sampleKeys 选出用于统计key迁移的不同key
+func sampleKeys(keys []string) []string {
+	seen := make(map[string]bool)
+	var sample []string
+	for _, key := range keys {
+		if !seen[key] {
+			seen[key] = true
+			sample = append(sample, key)
+			if len(sample) >= maxSampleKeys {
+				break
+			}
+		}
+	}
+	return sample
+}
+
+// mapping 返回每个key当前被分配到的服务器
+func mapping(lb balancer.Balancer, keys []string) map[string]string {
+	m := make(map[string]string, len(keys))
+	for _, key := range keys {
+		m[key], _ = lb.Balance(key)
+	}
+	return m
+}
+
+// workload 生成或读取请求的key
+func workload(cfg *Config) ([]string, error) {
+	if cfg.Workload != "" {
+		return readWorkload(cfg.Workload)
+	}
+	if cfg.Requests <= 0 {
+		return nil, errors.New("requests must be greater than 0")
+	}
+	n := cfg.Keys
+	if n <= 0 {
+		n = cfg.Requests
+	}
+
+	rnd := rand.New(rand.NewSource(cfg.Seed + 1))
+	next := func() int { return rnd.Intn(n) }
+	if cfg.Zipf > 1 {
+		zipf := rand.NewZipf(rnd, cfg.Zipf, 1, uint64(n-1))
+		next = func() int { return int(zipf.Uint64()) }
+	}
+
+	keys := make([]string, cfg.Requests)
+	for i := range keys {
+		// 合成的key是客户端IP，与默认的hash_key一致
+		k := next()
+		keys[i] = fmt.Sprintf("10.%d.%d.%d", k>>16&0xff, k>>8&0xff, k&0xff)
+	}
+	return keys, nil
+}
+
+// readWorkload 读取录制的请求文件，每行一个key
+func readWorkload(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var keys []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if key := strings.TrimSpace(scanner.Text()); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("workload %s is empty", filename)
+	}
+	return keys, nil
+}
+
+// percentile 返回已排序延迟的p分位数，p的范围是 [0, 1]
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(0, min(i, len(sorted)-1))]
+}